@@ -0,0 +1,662 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Originate originates a call to dialstring with the channel variables vars and
+// returns the new channel uuid. If app is not empty, the call is connected to
+// the application app with arguments appArgs, otherwise appArgs is the dialplan
+// extension target (e.g. "1000 XML default").
+func (con *Connection) Originate(dialstring, app, appArgs string, vars map[string]string) (string, error) {
+	target := appArgs
+	if app != "" {
+		target = fmt.Sprintf("&%s(%s)", app, appArgs)
+	}
+	resp, err := con.Api("originate", BuildVars(vars)+dialstring, target)
+	if err != nil {
+		return "", fmt.Errorf("originate: %w", err)
+	}
+	resp = strings.TrimSpace(resp)
+	if !strings.HasPrefix(resp, "+OK") {
+		return "", fmt.Errorf("originate: unexpected response: %s", resp)
+	}
+	return strings.TrimSpace(strings.TrimPrefix(resp, "+OK")), nil
+}
+
+// OriginateWithUUID is Originate with the new channel uuid chosen by the
+// caller (origination_uuid variable), e.g. to register event waiters for the
+// channel before it exists.
+func (con *Connection) OriginateWithUUID(uuid, dialstring, app, appArgs string, vars map[string]string) error {
+	if uuid == "" {
+		return fmt.Errorf("originate: empty uuid")
+	}
+	allVars := make(map[string]string, len(vars)+1)
+	for k, v := range vars {
+		allVars[k] = v
+	}
+	allVars["origination_uuid"] = uuid
+	got, err := con.Originate(dialstring, app, appArgs, allVars)
+	if err != nil {
+		return err
+	}
+	if got != uuid {
+		return fmt.Errorf("originate: channel uuid %s, not %s", got, uuid)
+	}
+	return nil
+}
+
+// Hangup hangs up the channel uuid with the given cause (NORMAL_CLEARING if empty).
+// It returns ErrNoSuchChannel if the channel doesn't exist.
+func (con *Connection) Hangup(uuid, cause string) error {
+	if cause == "" {
+		cause = "NORMAL_CLEARING"
+	}
+	if _, err := con.Api("uuid_kill", uuid, cause); err != nil {
+		return channelError("hangup "+uuid, err)
+	}
+	return nil
+}
+
+// Answer answers the channel uuid and waits for its CHANNEL_ANSWER event. It
+// fails if the channel hangs up first. The CHANNEL_ANSWER and CHANNEL_HANGUP
+// events must be subscribed (e.g. with MyEvents in outbound mode).
+func (con *Connection) Answer(uuid string) error {
+	return con.answer("answer", uuid, CHANNEL_ANSWER, "answered")
+}
+
+// PreAnswer establishes early media on the channel uuid and waits for its
+// CHANNEL_PROGRESS_MEDIA event, see Answer.
+func (con *Connection) PreAnswer(uuid string) error {
+	return con.answer("pre_answer", uuid, CHANNEL_PROGRESS_MEDIA, "early")
+}
+
+// answer executes app on channel uuid and waits for the event name. If it is
+// not received in con.EventTimeout (e.g. the channel was already answered), the
+// channel Answer-State is checked against state.
+func (con *Connection) answer(app, uuid string, name EventName, state string) error {
+	done := con.addWaiter(uuid, name, nil)
+	defer con.removeWaiter(done)
+	hangup := con.addWaiter(uuid, CHANNEL_HANGUP, nil)
+	defer con.removeWaiter(hangup)
+	if _, err := con.ExecuteSync(app, uuid); err != nil {
+		return channelError(app+" "+uuid, err)
+	}
+	if ok, err := con.awaitChannel(app+" "+uuid, done, hangup); ok || err != nil {
+		return err
+	}
+	vars, err := con.GetVars(uuid)
+	if err != nil {
+		return err
+	}
+	if got := vars["Answer-State"]; got != state && got != "answered" {
+		return fmt.Errorf("%s %s: answer state %q", app, uuid, got)
+	}
+	return nil
+}
+
+// awaitChannel waits for the event of done, failing if the channel hangs up
+// (event of hangup) or the connection is lost first. It returns false if no
+// event is received in con.EventTimeout.
+func (con *Connection) awaitChannel(msg string, done, hangup *eventWaiter) (bool, error) {
+	timer := time.NewTimer(con.eventTimeout())
+	defer timer.Stop()
+	select {
+	case <-done.ch:
+		return true, nil
+	case ev := <-hangup.ch:
+		return false, fmt.Errorf("%s: channel hung up (%s)", msg, ev.HangupCause())
+	case <-con.lostChan():
+		return false, fmt.Errorf("%s: %w", msg, ErrConnectionClosed)
+	case <-timer.C:
+		return false, nil
+	}
+}
+
+// Park parks the channel uuid with the park application and waits for its
+// CHANNEL_PARK event. It fails if the channel hangs up first, or with
+// ErrTimeout if the event is not received in con.EventTimeout. The CHANNEL_PARK and
+// CHANNEL_HANGUP events must be subscribed.
+func (con *Connection) Park(uuid string) error {
+	msg := "park " + uuid
+	done := con.addWaiter(uuid, CHANNEL_PARK, nil)
+	defer con.removeWaiter(done)
+	hangup := con.addWaiter(uuid, CHANNEL_HANGUP, nil)
+	defer con.removeWaiter(hangup)
+	if _, err := con.ExecuteSync("park", uuid); err != nil {
+		return channelError(msg, err)
+	}
+	ok, err := con.awaitChannel(msg, done, hangup)
+	if !ok && err == nil {
+		err = fmt.Errorf("%s: %w", msg, ErrTimeout)
+	}
+	return err
+}
+
+// Unpark transfers the parked channel uuid to the extension dest, see
+// Transfer, and waits for its CHANNEL_UNPARK event, as Park does.
+func (con *Connection) Unpark(uuid, dest, dialplan, context string) error {
+	msg := "unpark " + uuid
+	done := con.addWaiter(uuid, CHANNEL_UNPARK, nil)
+	defer con.removeWaiter(done)
+	hangup := con.addWaiter(uuid, CHANNEL_HANGUP, nil)
+	defer con.removeWaiter(hangup)
+	if err := con.transfer(msg, uuid, dest, dialplan, context); err != nil {
+		return err
+	}
+	ok, err := con.awaitChannel(msg, done, hangup)
+	if !ok && err == nil {
+		err = fmt.Errorf("%s: %w", msg, ErrTimeout)
+	}
+	return err
+}
+
+// Exists tells if the channel uuid exists, with uuid_exists.
+func (con *Connection) Exists(uuid string) (bool, error) {
+	resp, err := con.Api("uuid_exists", uuid)
+	if err != nil {
+		return false, fmt.Errorf("exists %s: %w", uuid, err)
+	}
+	switch resp = strings.TrimSpace(resp); resp {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("exists %s: unexpected response %q", uuid, resp)
+}
+
+// Bridge bridges the channels uuidA and uuidB. It returns ErrNoSuchChannel if
+// one of them doesn't exist.
+func (con *Connection) Bridge(uuidA, uuidB string) error {
+	if _, err := con.Api("uuid_bridge", uuidA, uuidB); err != nil {
+		return channelError("bridge "+uuidA+" "+uuidB, err)
+	}
+	return nil
+}
+
+// Transfer transfers the channel uuid to the extension dest of the given dialplan
+// and context (freeswitch defaults are used if empty). It returns
+// ErrNoSuchChannel if the channel doesn't exist and ErrInvalidExtension if dest
+// is invalid.
+func (con *Connection) Transfer(uuid, dest, dialplan, context string) error {
+	return con.transfer("transfer "+uuid, uuid, dest, dialplan, context)
+}
+
+// TransferBoth transfers the channel uuid and its bridged channel to the
+// extension dest, see Transfer.
+func (con *Connection) TransferBoth(uuid, dest, dialplan, context string) error {
+	return con.transfer("transfer both "+uuid, uuid+" -both", dest, dialplan, context)
+}
+
+// transfer runs uuid_transfer with the target (uuid and leg flag) and the
+// destination dest, dialplan and context.
+func (con *Connection) transfer(msg, target, dest, dialplan, context string) error {
+	if !validExtension(dest) {
+		return fmt.Errorf("%s: %q: %w", msg, dest, ErrInvalidExtension)
+	}
+	if context != "" && dialplan == "" {
+		dialplan = "XML"
+	}
+	args := []string{target, dest}
+	if dialplan != "" {
+		args = append(args, dialplan)
+	}
+	if context != "" {
+		args = append(args, context)
+	}
+	if _, err := con.Api("uuid_transfer", args...); err != nil {
+		return transferError(msg, err)
+	}
+	return nil
+}
+
+// DualTransfer transfers, at once, the bridged channels uuidA and uuidB to the
+// extensions destA and destB of the given dialplan and context (freeswitch
+// defaults are used if empty), with uuid_dual_transfer. It returns
+// ErrNoSuchChannel if a channel doesn't exist (or uuidB is not bridged to
+// uuidA) and ErrInvalidExtension if a destination is invalid.
+func (con *Connection) DualTransfer(uuidA, destA, uuidB, destB, dialplan, context string) error {
+	msg := "dual transfer " + uuidA + " " + uuidB
+	for _, dest := range []string{destA, destB} {
+		// '/' separates the extension from its dialplan and context
+		if !validExtension(dest) || strings.Contains(dest, "/") {
+			return fmt.Errorf("%s: %q: %w", msg, dest, ErrInvalidExtension)
+		}
+	}
+	peer, err := con.GetVar(uuidA, "bridge_uuid")
+	if err != nil {
+		return channelError(msg, err)
+	}
+	if peer != uuidB {
+		return fmt.Errorf("%s: %s not bridged to %s: %w", msg, uuidB, uuidA, ErrNoSuchChannel)
+	}
+	if context != "" && dialplan == "" {
+		dialplan = "XML"
+	}
+	suffix := ""
+	if dialplan != "" {
+		suffix += "/" + dialplan
+	}
+	if context != "" {
+		suffix += "/" + context
+	}
+	if _, err := con.Api("uuid_dual_transfer", uuidA, destA+suffix, destB+suffix); err != nil {
+		return transferError(msg, err)
+	}
+	return nil
+}
+
+// validExtension tells if dest can be used as a transfer destination.
+func validExtension(dest string) bool {
+	return dest != "" && !strings.ContainsAny(dest, " \t\r\n")
+}
+
+// GetVar returns the value of the channel variable name of channel uuid.
+// An undefined variable is returned as an empty string.
+func (con *Connection) GetVar(uuid, name string) (string, error) {
+	resp, err := con.Api("uuid_getvar", uuid, name)
+	if err != nil {
+		return "", channelError("getvar "+name, err)
+	}
+	resp = strings.TrimSpace(resp)
+	if resp == "_undef_" {
+		return "", nil
+	}
+	return resp, nil
+}
+
+// SetVar sets the channel variable name of channel uuid to value, escaped with
+// EscapeArg. The value can't contain newlines.
+func (con *Connection) SetVar(uuid, name, value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("setvar %s: value contains a newline", name)
+	}
+	if _, err := con.Api("uuid_setvar", uuid, name, EscapeArg(value)); err != nil {
+		return channelError("setvar "+name, err)
+	}
+	return nil
+}
+
+// MultiSet sets the channel variables vars of channel uuid in one round trip,
+// with uuid_setvar_multi. The values are escaped with EscapeArg, their ';'
+// (the assignments separator) with a backslash, and can't contain newlines.
+// Freeswitch handles at most 64 variables per call.
+func (con *Connection) MultiSet(uuid string, vars map[string]string) error {
+	if len(vars) == 0 {
+		return nil
+	}
+	if len(vars) > 64 {
+		return fmt.Errorf("multiset: %d variables, 64 max", len(vars))
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		if name == "" || strings.ContainsAny(name, "=; \t\r\n") {
+			return fmt.Errorf("multiset: invalid variable name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assigns := make([]string, len(names))
+	for i, name := range names {
+		value := vars[name]
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("multiset %s: value contains a newline", name)
+		}
+		assigns[i] = name + "=" + strings.Replace(EscapeArg(value), ";", `\;`, -1)
+	}
+	if _, err := con.Api("uuid_setvar_multi", uuid, strings.Join(assigns, ";")); err != nil {
+		return channelError("multiset", err)
+	}
+	return nil
+}
+
+// GetVars returns the fields of uuid_dump for channel uuid, unescaped. Channel
+// variables are the fields prefixed with "variable_".
+func (con *Connection) GetVars(uuid string) (map[string]string, error) {
+	resp, err := con.Api("uuid_dump", uuid)
+	if err != nil {
+		return nil, channelError("dump "+uuid, err)
+	}
+	vars := make(map[string]string)
+	for _, line := range strings.Split(resp, "\n") {
+		i := strings.Index(line, ": ")
+		if i < 0 {
+			continue
+		}
+		val, err := url.PathUnescape(strings.TrimSpace(line[i+2:]))
+		if err != nil {
+			val = strings.TrimSpace(line[i+2:])
+		}
+		vars[line[:i]] = val
+	}
+	return vars, nil
+}
+
+// Channel holds the commonly used fields of a channel dump.
+type Channel struct {
+	UUID              string
+	CallerIDName      string
+	CallerIDNumber    string
+	DestinationNumber string
+	ChannelState      string
+	Direction         string
+	ReadCodec         string
+	WriteCodec        string
+	Vars              map[string]string // all the dump fields, variables are prefixed with "variable_"
+}
+
+// ChannelInfo returns the channel uuid information, from its json dump.
+func (con *Connection) ChannelInfo(uuid string) (*Channel, error) {
+	resp, err := con.Api("uuid_dump", uuid, "json")
+	if err != nil {
+		return nil, channelError("dump "+uuid, err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(resp), &fields); err != nil {
+		return nil, fmt.Errorf("dump %s: parse json: %v", uuid, err)
+	}
+	ch := &Channel{Vars: make(map[string]string, len(fields))}
+	for k, v := range fields {
+		if s, ok := v.(string); ok {
+			ch.Vars[k] = s
+		} else {
+			ch.Vars[k] = fmt.Sprint(v)
+		}
+	}
+	ch.UUID = ch.Vars["Unique-ID"]
+	ch.CallerIDName = ch.Vars["Caller-Caller-ID-Name"]
+	ch.CallerIDNumber = ch.Vars["Caller-Caller-ID-Number"]
+	ch.DestinationNumber = ch.Vars["Caller-Destination-Number"]
+	ch.ChannelState = ch.Vars["Channel-State"]
+	ch.Direction = ch.Vars["Call-Direction"]
+	ch.ReadCodec = ch.Vars["Channel-Read-Codec-Name"]
+	ch.WriteCodec = ch.Vars["Channel-Write-Codec-Name"]
+	return ch, nil
+}
+
+// Playback plays file on channel uuid and waits for the end of the playback. It
+// returns the CHANNEL_EXECUTE_COMPLETE event, which has the playback_status and
+// playback_terminator variables. If ctx is done first, the playback is stopped
+// with uuid_break and ctx.Err() is returned. CHANNEL_EXECUTE_COMPLETE events
+// must be subscribed.
+func (con *Connection) Playback(ctx context.Context, uuid, file string) (*Event, error) {
+	done, err := con.ExecuteAsync("playback", uuid, file)
+	if err != nil {
+		return nil, fmt.Errorf("playback: %w", err)
+	}
+	select {
+	case ev, ok := <-done:
+		if !ok {
+			return nil, ErrConnectionClosed
+		}
+		return ev, nil
+	case <-ctx.Done():
+		if _, err := con.Api("uuid_break", uuid); err != nil {
+			con.logf("ERR: playback: break %s: %v\n", uuid, err)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Broadcast plays path on the leg "aleg", "bleg" or "both" (aleg if empty) of
+// the call of channel uuid, with uuid_broadcast. It doesn't wait for the end
+// of the playback.
+func (con *Connection) Broadcast(uuid, path, leg string) error {
+	switch leg {
+	case "":
+		leg = "aleg"
+	case "aleg", "bleg", "both":
+	default:
+		return fmt.Errorf("broadcast: invalid leg %q (aleg, bleg or both)", leg)
+	}
+	if _, err := con.Api("uuid_broadcast", uuid, path, leg); err != nil {
+		return channelError("broadcast "+uuid, err)
+	}
+	return nil
+}
+
+// PlayGetDigitsOpts are the play_and_get_digits application options.
+type PlayGetDigitsOpts struct {
+	Min             int           // minimum number of digits
+	Max             int           // maximum number of digits
+	Tries           int           // number of tries
+	Timeout         time.Duration // time to wait for digits after the file is played
+	TerminatorChars string        // digits ending the input, e.g. "#"
+	File            string        // file to play
+	InvalidFile     string        // file played on invalid input (silence if empty)
+	VarName         string        // channel variable receiving the digits
+	Regex           string        // digits validation regexp (\d+ if empty)
+}
+
+// PlayAndGetDigits runs play_and_get_digits on channel uuid with opts and returns
+// the collected digits, read back from the opts.VarName channel variable.
+func (con *Connection) PlayAndGetDigits(uuid string, opts PlayGetDigitsOpts) (string, error) {
+	if opts.Min > opts.Max {
+		return "", fmt.Errorf("play_and_get_digits: min %d > max %d", opts.Min, opts.Max)
+	}
+	if opts.VarName == "" {
+		return "", fmt.Errorf("play_and_get_digits: empty var name")
+	}
+	if opts.InvalidFile == "" {
+		opts.InvalidFile = "silence_stream://250"
+	}
+	if opts.Regex == "" {
+		opts.Regex = `\d+`
+	}
+	if opts.TerminatorChars == "" {
+		opts.TerminatorChars = "none"
+	}
+	args := []string{
+		strconv.Itoa(opts.Min),
+		strconv.Itoa(opts.Max),
+		strconv.Itoa(opts.Tries),
+		strconv.FormatInt(int64(opts.Timeout/time.Millisecond), 10),
+		EscapeArg(opts.TerminatorChars),
+		EscapeArg(opts.File),
+		EscapeArg(opts.InvalidFile),
+		opts.VarName,
+		EscapeArg(opts.Regex),
+	}
+	if _, err := con.ExecuteSync("play_and_get_digits", uuid, args...); err != nil {
+		return "", fmt.Errorf("play_and_get_digits: %w", err)
+	}
+	return con.GetVar(uuid, opts.VarName)
+}
+
+// SendDTMF sends digits on channel uuid with the send_dtmf application, each
+// digit lasting durationMs milliseconds if durationMs > 0.
+func (con *Connection) SendDTMF(uuid, digits string, durationMs int) error {
+	if digits == "" {
+		return fmt.Errorf("send_dtmf: empty digits")
+	}
+	for _, d := range digits {
+		if !strings.ContainsRune("0123456789ABCD*#", d) {
+			return fmt.Errorf("send_dtmf: invalid digit %q", d)
+		}
+	}
+	if durationMs > 0 {
+		digits += "@" + strconv.Itoa(durationMs)
+	}
+	if _, err := con.ExecuteSync("send_dtmf", uuid, digits); err != nil {
+		return fmt.Errorf("send_dtmf: %w", err)
+	}
+	return nil
+}
+
+// RecordOpts are the Record options.
+type RecordOpts struct {
+	MaxDurationSec   int  // recording time limit, no limit if 0
+	SilenceThreshold int  // energy level under which audio is silence (record app only)
+	SilenceHits      int  // silence hits ending the recording (record app only)
+	Stereo           bool // record each leg in its own channel (RECORD_STEREO)
+}
+
+// Record records channel uuid into the file path. It uses the record_session
+// application, running in the background until StopRecord or the hangup, unless
+// opts.SilenceThreshold is set: the record application is then used and the
+// recording stops on silence.
+func (con *Connection) Record(uuid, path string, opts RecordOpts) error {
+	if opts.Stereo {
+		if err := con.SetVar(uuid, "RECORD_STEREO", "true"); err != nil {
+			return fmt.Errorf("record: %w", err)
+		}
+	}
+	app, args := "record_session", []string{path}
+	if opts.SilenceThreshold > 0 {
+		app = "record"
+		args = append(args, strconv.Itoa(opts.MaxDurationSec),
+			strconv.Itoa(opts.SilenceThreshold), strconv.Itoa(opts.SilenceHits))
+	} else if opts.MaxDurationSec > 0 {
+		args = append(args, "+"+strconv.Itoa(opts.MaxDurationSec))
+	}
+	if _, err := con.ExecuteSync(app, uuid, args...); err != nil {
+		return fmt.Errorf("%s: %w", app, err)
+	}
+	return nil
+}
+
+// StopRecord stops the record_session recording of channel uuid into path.
+func (con *Connection) StopRecord(uuid, path string) error {
+	if _, err := con.ExecuteSync("stop_record_session", uuid, path); err != nil {
+		return fmt.Errorf("stop_record_session: %w", err)
+	}
+	return nil
+}
+
+// DisplaceOpts are the StartDisplace options.
+type DisplaceOpts struct {
+	LimitSec int  // displacement time limit, no limit if 0
+	Mux      bool // mix the file with the channel audio instead of replacing it
+}
+
+// StartDisplace replaces (or mixes with, see opts.Mux) the audio of channel uuid
+// with file, with uuid_displace. It can be used for background music.
+func (con *Connection) StartDisplace(uuid, file string, opts DisplaceOpts) error {
+	args := []string{uuid, "start", file}
+	if opts.LimitSec > 0 || opts.Mux {
+		args = append(args, strconv.Itoa(opts.LimitSec))
+	}
+	if opts.Mux {
+		args = append(args, "mux")
+	}
+	if _, err := con.Api("uuid_displace", args...); err != nil {
+		return channelError("start displace "+uuid, err)
+	}
+	return nil
+}
+
+// StopDisplace stops the displacement of channel uuid audio with file. The file
+// must be the one given to StartDisplace: freeswitch uses it to find the media
+// bug.
+func (con *Connection) StopDisplace(uuid, file string) error {
+	if _, err := con.Api("uuid_displace", uuid, "stop", file); err != nil {
+		return channelError("stop displace "+uuid, err)
+	}
+	return nil
+}
+
+// AudioLevel sets the read (or write, if read is false) audio volume level of
+// channel uuid with uuid_audio. The level is between -4 and 4, 0 being the
+// normal volume.
+func (con *Connection) AudioLevel(uuid string, read bool, level int) error {
+	if level < -4 || level > 4 {
+		return fmt.Errorf("audio level %d out of range [-4, 4]", level)
+	}
+	dir := "write"
+	if read {
+		dir = "read"
+	}
+	if _, err := con.Api("uuid_audio", uuid, "start", dir, "level", strconv.Itoa(level)); err != nil {
+		return channelError("audio level "+uuid, err)
+	}
+	return nil
+}
+
+// UnicastConfig is the Unicast configuration. The local address is the one
+// freeswitch binds, the remote one is where the channel audio is sent.
+type UnicastConfig struct {
+	LocalIP    string
+	LocalPort  int
+	RemoteIP   string
+	RemotePort int
+	Transport  string // "udp" (default) or "tcp"
+	Flags      string // e.g. "native" to send the audio without transcoding
+}
+
+// Unicast streams the audio of channel uuid to (and reads audio from) the
+// cfg.RemoteIP:cfg.RemotePort socket, with the unicast sendmsg call-command.
+func (con *Connection) Unicast(uuid string, cfg UnicastConfig) error {
+	if cfg.Transport == "" {
+		cfg.Transport = "udp"
+	}
+	if cfg.Transport != "udp" && cfg.Transport != "tcp" {
+		return fmt.Errorf("unicast: invalid transport %q (udp or tcp)", cfg.Transport)
+	}
+	if cfg.LocalIP == "" || cfg.RemoteIP == "" || cfg.LocalPort <= 0 || cfg.RemotePort <= 0 {
+		return fmt.Errorf("unicast: local and remote addresses are mandatory")
+	}
+	hdrs := map[string]string{
+		"local-ip":    cfg.LocalIP,
+		"local-port":  strconv.Itoa(cfg.LocalPort),
+		"remote-ip":   cfg.RemoteIP,
+		"remote-port": strconv.Itoa(cfg.RemotePort),
+		"transport":   cfg.Transport,
+	}
+	if cfg.Flags != "" {
+		hdrs["flags"] = cfg.Flags
+	}
+	cmd := Command{UId: uuid, CallCommand: "unicast", Headers: hdrs}
+	if _, err := cmd.Execute(con); err != nil {
+		return fmt.Errorf("unicast: %w", err)
+	}
+	return nil
+}
+
+// BuildVars formats vars as a {var1=val1,var2=val2} channel variables block,
+// sorted by name, the values being escaped with EscapeArg. It returns an empty
+// string if vars is empty.
+func BuildVars(vars map[string]string) string {
+	if len(vars) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	buf.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(name + "=" + EscapeArg(vars[name]))
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// EscapeArg escapes s to be used as a single application or api argument (or
+// channel variable value). If s is empty or contains delimiters (spaces, commas,
+// pipes, braces...), quotes or backslashes, it is wrapped in single quotes,
+// embedded quotes and backslashes being escaped with a backslash.
+func EscapeArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t,|'\"\\{}[]") {
+		return s
+	}
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "'", `\'`, -1)
+	return "'" + s + "'"
+}
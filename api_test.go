@@ -0,0 +1,127 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParkOutbound(t *testing.T) {
+	con, s := outboundConnection(t, newTestHandler(), "1234")
+	s.serve(func(cmd string) {
+		s.reply("+OK")
+		if strings.Contains(cmd, "execute-app-name: park") {
+			time.Sleep(20 * time.Millisecond)
+			s.event("Event-Name: CHANNEL_PARK\nUnique-ID: 1234\n\n")
+		}
+	})
+	handleEvents(con)
+	if err := con.Park("1234"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParkTimeout(t *testing.T) {
+	con, s := outboundConnection(t, newTestHandler(), "1234")
+	con.EventTimeout = 50 * time.Millisecond
+	s.serve(func(cmd string) { s.reply("+OK") })
+	handleEvents(con)
+	if err := con.Park("1234"); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+}
+
+func TestParkHangup(t *testing.T) {
+	con, s := outboundConnection(t, newTestHandler(), "1234")
+	s.serve(func(cmd string) {
+		s.reply("+OK")
+		s.event("Event-Name: CHANNEL_HANGUP\nUnique-ID: 1234\nHangup-Cause: NORMAL_CLEARING\n\n")
+	})
+	handleEvents(con)
+	if err := con.Park("1234"); err == nil || !strings.Contains(err.Error(), "NORMAL_CLEARING") {
+		t.Fatalf("got %v, want a hangup error", err)
+	}
+}
+
+func TestMultiSet(t *testing.T) {
+	con, s := pipeConnection(t, newTestHandler())
+	cmds := make(chan string, 10)
+	s.serve(func(cmd string) {
+		cmds <- cmd
+		s.apiResponse("+OK")
+	})
+	handleEvents(con)
+	tooMany := make(map[string]string)
+	for i := 0; i < 65; i++ {
+		tooMany[fmt.Sprintf("v%d", i)] = "x"
+	}
+	for _, vars := range []map[string]string{
+		tooMany,
+		{"": "x"},
+		{"a=b": "x"},
+		{"a;b": "x"},
+		{"a b": "x"},
+		{"a": "x\ny"},
+	} {
+		if err := con.MultiSet("1234", vars); err == nil {
+			t.Errorf("%v: got no error", vars)
+		}
+	}
+	vars := map[string]string{
+		"zeta":  "plain",
+		"alpha": "a;b",
+		"mid":   "it's a;b",
+	}
+	if err := con.MultiSet("1234", vars); err != nil {
+		t.Fatal(err)
+	}
+	want := `api uuid_setvar_multi 1234 alpha=a\;b;mid='it\'s a\;b';zeta=plain`
+	if cmd := <-cmds; cmd != want {
+		t.Errorf("got command %q, want %q", cmd, want)
+	}
+}
+
+func TestEscapeArg(t *testing.T) {
+	for _, tc := range []struct {
+		arg  string
+		want string
+	}{
+		{"plain", "plain"},
+		{"", "''"},
+		{"two words", "'two words'"},
+		{"a,b", "'a,b'"},
+		{"a|b", "'a|b'"},
+		{"{x}", "'{x}'"},
+		{"[x]", "'[x]'"},
+		{"it's", `'it\'s'`},
+		{`back\slash`, `'back\\slash'`},
+		{`say "hi"`, `'say "hi"'`},
+	} {
+		if got := EscapeArg(tc.arg); got != tc.want {
+			t.Errorf("EscapeArg(%q): got %s, want %s", tc.arg, got, tc.want)
+		}
+	}
+}
+
+func TestBuildVars(t *testing.T) {
+	for _, tc := range []struct {
+		vars map[string]string
+		want string
+	}{
+		{nil, ""},
+		{map[string]string{}, ""},
+		{map[string]string{"a": "1"}, "{a=1}"},
+		{map[string]string{"zeta": "z", "alpha": "a b", "mid": "x,y"}, "{alpha='a b',mid='x,y',zeta=z}"},
+		{map[string]string{"empty": ""}, "{empty=''}"},
+	} {
+		if got := BuildVars(tc.vars); got != tc.want {
+			t.Errorf("BuildVars(%v): got %s, want %s", tc.vars, got, tc.want)
+		}
+	}
+}
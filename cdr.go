@@ -0,0 +1,99 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CDR holds the billing fields of a CHANNEL_HANGUP_COMPLETE event.
+type CDR struct {
+	CallUUID          string
+	CallerIDNumber    string
+	DestinationNumber string
+	StartStamp        time.Time
+	AnswerStamp       time.Time // zero if the call was not answered
+	EndStamp          time.Time
+	Duration          time.Duration // from start to end
+	BillSec           time.Duration // from answer to end
+	HangupCause       HangupCause
+}
+
+// CDR returns the billing fields of the CHANNEL_HANGUP_COMPLETE event e. The
+// event carries the channel variables, whose stamps are read from the
+// *_uepoch variables (microseconds) or else the *_epoch ones.
+func (e *Event) CDR() (*CDR, error) {
+	if e.Name != CHANNEL_HANGUP_COMPLETE {
+		return nil, fmt.Errorf("cdr: not a CHANNEL_HANGUP_COMPLETE event: %s", e.Name)
+	}
+	cdr := &CDR{
+		CallUUID:          e.Get("Unique-ID"),
+		CallerIDNumber:    e.Get("Caller-Caller-ID-Number"),
+		DestinationNumber: e.Get("Caller-Destination-Number"),
+		HangupCause:       ParseHangupCause(e.Get("variable_hangup_cause")),
+	}
+	if cdr.CallUUID == "" {
+		return nil, fmt.Errorf("cdr: no Unique-ID")
+	}
+	if cdr.HangupCause == HangupNone {
+		cdr.HangupCause = e.HangupCause()
+	}
+	var err error
+	if cdr.StartStamp, err = e.cdrStamp("start"); err != nil {
+		return nil, err
+	}
+	if cdr.AnswerStamp, err = e.cdrStamp("answer"); err != nil {
+		return nil, err
+	}
+	if cdr.EndStamp, err = e.cdrStamp("end"); err != nil {
+		return nil, err
+	}
+	if cdr.Duration, err = e.cdrSeconds("duration"); err != nil {
+		return nil, err
+	}
+	if cdr.BillSec, err = e.cdrSeconds("billsec"); err != nil {
+		return nil, err
+	}
+	return cdr, nil
+}
+
+// cdrStamp returns the time of the name_uepoch (or name_epoch) channel
+// variable of e, or the zero time if it is missing or 0.
+func (e *Event) cdrStamp(name string) (time.Time, error) {
+	v, micro := e.Get("variable_"+name+"_uepoch"), true
+	if v == "" {
+		v, micro = e.Get("variable_"+name+"_epoch"), false
+	}
+	if v == "" {
+		return time.Time{}, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cdr: %s stamp %q: %v", name, v, err)
+	}
+	if n == 0 {
+		return time.Time{}, nil
+	}
+	if micro {
+		return time.UnixMicro(n), nil
+	}
+	return time.Unix(n, 0), nil
+}
+
+// cdrSeconds returns the duration of the name channel variable of e, in
+// seconds, or 0 if it is missing.
+func (e *Event) cdrSeconds(name string) (time.Duration, error) {
+	v := e.Get("variable_" + name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("cdr: %s %q: %v", name, v, err)
+	}
+	return time.Duration(n) * time.Second, nil
+}
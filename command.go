@@ -6,7 +6,10 @@ package esl
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"sort"
+	"strings"
 )
 
 type Command struct {
@@ -14,29 +17,66 @@ type Command struct {
 	UId  string
 	App  string
 	Args string
+	// CallCommand is the sendmsg call-command: execute (if empty), hangup,
+	// unicast, nomedia... App, Args and Sync are only used by execute.
+	CallCommand string
+	// Loops is the number of times the application is executed (loops
+	// header), only written if greater than 0.
+	Loops int
+	// EventUUID, if not empty, is sent as Event-UUID header. Freeswitch
+	// echoes it as Application-UUID in the execute events of the command.
+	EventUUID string
+	// Headers are additional sendmsg headers (e.g. hangup-cause), written
+	// sorted by name.
+	Headers map[string]string
 }
 
 // Serialize formats (serializes) the command as expected by freeswitch.
 func (cmd *Command) Serialize() []byte {
 	var buf bytes.Buffer
-	buf.WriteString(fmt.Sprintf("sendmsg %s\ncall-command: execute\n", cmd.UId))
-	buf.WriteString(fmt.Sprintf("execute-app-name: %s\nexecute-app-arg: %s\n", cmd.App, cmd.Args))
-	if cmd.Sync {
-		buf.WriteString("event-lock: true\n")
-	} else {
-		buf.WriteString("event-lock: false\n")
+	callCommand := cmd.CallCommand
+	if callCommand == "" {
+		callCommand = "execute"
+	}
+	buf.WriteString(fmt.Sprintf("sendmsg %s\ncall-command: %s\n", cmd.UId, callCommand))
+	if callCommand == "execute" {
+		buf.WriteString(fmt.Sprintf("execute-app-name: %s\nexecute-app-arg: %s\n", cmd.App, cmd.Args))
+		if cmd.Loops > 0 {
+			buf.WriteString(fmt.Sprintf("loops: %d\n", cmd.Loops))
+		}
+	}
+	if cmd.EventUUID != "" {
+		buf.WriteString(fmt.Sprintf("Event-UUID: %s\n", cmd.EventUUID))
+	}
+	names := make([]string, 0, len(cmd.Headers))
+	for name := range cmd.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		buf.WriteString(fmt.Sprintf("%s: %s\n", name, cmd.Headers[name]))
+	}
+	if callCommand == "execute" {
+		if cmd.Sync {
+			buf.WriteString("event-lock: true\n")
+		} else {
+			buf.WriteString("event-lock: false\n")
+		}
 	}
 	buf.WriteString("\n\n")
 	return buf.Bytes()
 }
 
 // Execute sends Command cmd over Connection and waits for reply.
-// Returns the command reply event pointer or an error if any.
+// Returns the command reply event pointer or an error if any (a *CommandError
+// if the reply is -ERR).
 func (cmd Command) Execute(con *Connection) (*Event, error) {
-	_, err := con.Write(cmd.Serialize())
+	ev, err := con.exchange(context.Background(), cmd.Serialize(), &con.cmdReplies)
 	if err != nil {
-		return nil, fmt.Errorf("execute command: con write: %v", err)
+		return nil, fmt.Errorf("execute command: %w", err)
+	}
+	if reply := ev.Get("Reply-Text"); strings.HasPrefix(reply, "-ERR") {
+		return nil, &CommandError{Command: "sendmsg " + cmd.UId, Reply: strings.TrimSpace(reply)}
 	}
-	ev := <-con.cmdReply
 	return ev, nil
 }
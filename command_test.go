@@ -0,0 +1,99 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCommandErrorsWrapped(t *testing.T) {
+	con, s := pipeConnection(t, newTestHandler())
+	con.CommandTimeout = 50 * time.Millisecond
+	s.serve(func(cmd string) {}) // never replies
+	handleEvents(con)
+	for name, fn := range map[string]func() error{
+		"execute": func() error {
+			_, err := con.Execute("answer", "1234", "")
+			return err
+		},
+		"execute sync": func() error {
+			_, err := con.ExecuteSync("answer", "1234")
+			return err
+		},
+		"send event": func() error {
+			_, err := con.SendEvent("CUSTOM", map[string]string{"Event-Subclass": "test"}, nil)
+			return err
+		},
+		"bgapi job": func() error {
+			_, err := con.BgApiJob(context.Background(), "status")
+			return err
+		},
+	} {
+		if err := fn(); !errors.Is(err, ErrTimeout) {
+			t.Errorf("%s: got %v, want ErrTimeout", name, err)
+		}
+	}
+	// handshake errors, with the freeswitch end closed early
+	for _, tc := range []struct {
+		name   string
+		server func(server net.Conn)
+		fn     func(client net.Conn) error
+	}{
+		{"auth", func(server net.Conn) {
+			server.Close()
+		}, func(client net.Conn) error {
+			con := newConnection("pipe", newTestHandler())
+			con.setSocket(client)
+			err := con.Authenticate()
+			var authErr *AuthError
+			if !errors.As(err, &authErr) {
+				t.Errorf("auth: got %v, want an *AuthError", err)
+			}
+			return err
+		}},
+		{"outbound connect", func(server net.Conn) {
+			bufio.NewReader(server).ReadString('\n')
+			server.Close()
+		}, func(client net.Conn) error {
+			_, err := NewOutboundConnection(client, newTestHandler())
+			return err
+		}},
+	} {
+		client, server := net.Pipe()
+		go tc.server(server)
+		if err := tc.fn(client); !errors.Is(err, io.EOF) {
+			t.Errorf("%s: got %v, want io.EOF", tc.name, err)
+		}
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	for _, tc := range []struct {
+		cmd  Command
+		want string
+	}{
+		{Command{UId: "1234", App: "answer"},
+			"sendmsg 1234\ncall-command: execute\nexecute-app-name: answer\nexecute-app-arg: \nevent-lock: false\n\n\n"},
+		{Command{UId: "1234", App: "playback", Args: "beep.wav", Sync: true, Loops: 3},
+			"sendmsg 1234\ncall-command: execute\nexecute-app-name: playback\nexecute-app-arg: beep.wav\nloops: 3\nevent-lock: true\n\n\n"},
+		{Command{UId: "1234", App: "playback", Args: "beep.wav", EventUUID: "5678",
+			Headers: map[string]string{"hold-bcast": "true", "content-type": "text/plain", "b": "2"}},
+			"sendmsg 1234\ncall-command: execute\nexecute-app-name: playback\nexecute-app-arg: beep.wav\n" +
+				"Event-UUID: 5678\nb: 2\ncontent-type: text/plain\nhold-bcast: true\nevent-lock: false\n\n\n"},
+		{Command{UId: "1234", CallCommand: "hangup", App: "ignored", Loops: 2, Sync: true,
+			Headers: map[string]string{"hangup-cause": "NORMAL_CLEARING"}},
+			"sendmsg 1234\ncall-command: hangup\nhangup-cause: NORMAL_CLEARING\n\n\n"},
+	} {
+		if got := string(tc.cmd.Serialize()); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
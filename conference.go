@@ -0,0 +1,73 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConfMember is a conference member, as listed by ConfList.
+type ConfMember struct {
+	MemberID       string
+	Dialstring     string
+	UUID           string
+	CallerIDName   string
+	CallerIDNumber string
+	Flags          []string // hear, speak, talking, floor...
+}
+
+// ConfKick kicks the member memberID out of the conference conf.
+func (con *Connection) ConfKick(conf, memberID string) error {
+	return con.confMember(conf, "kick", memberID)
+}
+
+// ConfMute mutes the member memberID of the conference conf.
+func (con *Connection) ConfMute(conf, memberID string) error {
+	return con.confMember(conf, "mute", memberID)
+}
+
+// ConfUnmute unmutes the member memberID of the conference conf.
+func (con *Connection) ConfUnmute(conf, memberID string) error {
+	return con.confMember(conf, "unmute", memberID)
+}
+
+// confMember runs the conference action on the member memberID.
+func (con *Connection) confMember(conf, action, memberID string) error {
+	resp, err := con.Api("conference", conf, action, memberID)
+	if err != nil {
+		return fmt.Errorf("conference %s %s: %w", conf, action, err)
+	}
+	// conference reports unknown members without -ERR
+	if resp = strings.TrimSpace(resp); strings.HasPrefix(resp, "Non-Exist") {
+		return fmt.Errorf("conference %s %s: %s", conf, action, resp)
+	}
+	return nil
+}
+
+// ConfList returns the members of the conference conf.
+func (con *Connection) ConfList(conf string) ([]ConfMember, error) {
+	resp, err := con.Api("conference", conf, "list")
+	if err != nil {
+		return nil, fmt.Errorf("conference %s list: %w", conf, err)
+	}
+	var members []ConfMember
+	for _, line := range strings.Split(resp, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ";")
+		if len(fields) < 6 {
+			// empty line or conference not found
+			continue
+		}
+		members = append(members, ConfMember{
+			MemberID:       fields[0],
+			Dialstring:     fields[1],
+			UUID:           fields[2],
+			CallerIDName:   fields[3],
+			CallerIDNumber: fields[4],
+			Flags:          strings.Split(fields[5], "|"),
+		})
+	}
+	return members, nil
+}
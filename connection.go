@@ -7,11 +7,16 @@ package esl
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
 	"fmt"
 	"io"
-	"log"
 	"net"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,61 +27,403 @@ type ConnectionHandler interface {
 	OnClose(con *Connection)
 }
 
+// Connection is an esl connection to freeswitch.
+//
+// Commands (SendRecv, SendEvent, Api, Execute...) may be called from several
+// goroutines: they are written in turn and, as freeswitch replies in order,
+// their replies are paired with them through FIFO queues of waiting callers.
 type Connection struct {
-	socket     net.Conn
-	buffer     *bufio.ReadWriter
-	cmdReply   chan *Event
-	apiResp    chan *Event
-	Handler    ConnectionHandler
-	Address    string
-	Password   string
-	Connected  bool
-	MaxRetries int
-	Timeout    time.Duration
-	UserData   interface{}
-}
-
-func NewConnection(host string, handler ConnectionHandler) (*Connection, error) {
-	con := Connection{
-		Address:  host,
-		Password: "ClueCon",
-		Timeout:  3 * time.Second,
-		Handler:  handler,
-	}
-	con.cmdReply = make(chan *Event)
-	con.apiResp = make(chan *Event)
-	err := con.ConnectRetry(3)
-	if err != nil {
-		return nil, fmt.Errorf("connect: %v", err)
+	socketMu         sync.Mutex // guards socket, replaced on reconnect
+	socket           net.Conn
+	buffer           *bufio.ReadWriter // replaced with writeMu held
+	writeMu          sync.Mutex        // serializes socket writes (and reply queues pushes)
+	jobsMu           sync.Mutex
+	jobs             map[string]*Job // pending bgapi jobs by Job-UUID
+	subsMu           sync.Mutex
+	subFormat        string        // format of the current subscription
+	subNames         []EventName   // current subscription, replayed on reconnect
+	connected        atomic.Bool   // set once authenticated, see IsConnected
+	closed           atomic.Bool   // set by Close, disables auto reconnect
+	rejected         atomic.Bool   // set on rude rejection, disables auto reconnect
+	exiting          atomic.Bool   // set by Exit, disables auto reconnect
+	exitNotice       chan struct{} // closed on the disconnect notice following exit
+	handlersMu       sync.Mutex
+	subclassHandlers map[string]EventFunc
+	nameHandlers     map[EventName][]EventFunc  // On handlers
+	anyHandlers      []EventFunc                // OnAny handlers
+	eventQueue       chan func()                // EventWorkers queue
+	waiters          map[waitKey][]*eventWaiter // WaitEvent waiters
+	sessions         map[string]*Session        // tracked sessions, by uuid
+	userMu           sync.Mutex                 // guards UserData for SetUserData and UserDataAs
+	stateMu          sync.Mutex
+	states           chan ConnState // StateChanges channel
+	lostMu           sync.Mutex
+	lost             chan struct{} // closed when the current socket is lost or closed
+	lastEvent        atomic.Int64  // unix nano time of the last received event
+	hbExpired        atomic.Bool   // set by the heartbeat watchdog
+	cmdReplies       replyQueue    // callers waiting for a command/reply, only gets EventCommandReply
+	apiResponses     replyQueue    // callers waiting for an api/response, only gets EventApiResponse
+	Handler          ConnectionHandler
+	Address          string
+	Password         string
+	MaxRetries       int
+	Timeout          time.Duration
+	// UserData is free for the application use. SetUserData and UserDataAs
+	// access it safely from several goroutines.
+	UserData interface{}
+	// Logger is used for the connection logging. DefaultLogger is used if nil.
+	Logger Logger
+	// AuthCommand, if not empty, is the authentication command sent instead
+	// of "auth <Password>", e.g. "userauth user@domain:password".
+	AuthCommand string
+	// TLSConfig, if not nil, is used to dial freeswitch over TLS.
+	TLSConfig *tls.Config
+	// Outbound is true when the connection was initiated by freeswitch
+	// (socket dialplan application) and accepted by ListenAndServe.
+	Outbound bool
+	// ChannelData holds, in outbound mode, the channel data event sent by
+	// freeswitch in reply to the initial connect command.
+	ChannelData *Event
+	// CommandTimeout, if not zero, is the maximum time to wait for the reply
+	// of a command (SendRecv, SendEvent, Api, Execute...). ErrTimeout is
+	// returned when it is exceeded.
+	CommandTimeout time.Duration
+	// EventTimeout is the maximum time to wait for the events confirming a
+	// command (e.g. CHANNEL_PARK for Park, the disconnect notice for Exit).
+	// DefaultEventTimeout is used if zero.
+	EventTimeout time.Duration
+	// ReadBufferSize is the size of the socket read buffer.
+	// DefaultReadBufferSize is used if zero.
+	ReadBufferSize int
+	// MaxBodySize is the maximum size of an event body. Larger events are
+	// dropped. DefaultMaxBodySize is used if zero.
+	MaxBodySize int
+	// MaxHeaderBytes is the maximum size of an event header section. A larger
+	// header (ErrHeaderTooLarge) is a read error: the connection is closed, or
+	// reconnected if AutoReconnect is set. DefaultMaxHeaderBytes is used if zero.
+	MaxHeaderBytes int
+	// BodyPool, if not nil, is a pool of *[]byte buffers the event bodies are
+	// read into. The buffer of an event is only reused once it is given back
+	// with ReleaseEvent, when all its handlers are done with it.
+	BodyPool *sync.Pool
+	// HeartbeatTimeout, if not zero, is the maximum time without receiving any
+	// event before the connection is considered dead and HandleEvents returns
+	// ErrHeartbeatTimeout (or reconnects if AutoReconnect is set). Subscribe
+	// to HEARTBEAT events to get one every 20 seconds from freeswitch.
+	HeartbeatTimeout time.Duration
+	// KeepAliveInterval, if not zero, is the interval of the "api status"
+	// commands sent by HandleEvents to keep the connection active.
+	KeepAliveInterval time.Duration
+	// TCPKeepAlivePeriod is the TCP keepalive period of the inbound
+	// connections socket, so that connections silently dropped (e.g. by a
+	// load balancer) are detected. DefaultTCPKeepAlivePeriod is used if zero,
+	// a negative value disables TCP keepalive.
+	TCPKeepAlivePeriod time.Duration
+	// AutoReconnect makes HandleEvents reconnect (with exponential backoff,
+	// at most MaxRetries attempts) when the connection to freeswitch is lost.
+	// Subscriptions are then replayed and Handler.OnConnect is called again.
+	AutoReconnect bool
+	// OnHandlerPanic, if set, is called with the recovered value when an
+	// event handler (Handler methods or registered handlers) panics. The
+	// panic is logged and the connection keeps running in any case.
+	OnHandlerPanic func(recovered interface{})
+	// OnCommand, if set, is called after each command waiting for a reply
+	// (SendRecv, Api, Execute, SendEvent, Pipeline, ApiStream...) with the
+	// command name (e.g. "bgapi originate" or "api status", without the
+	// arguments), its round trip time from the write to the reply, and its
+	// error, if any (a -ERR command reply or api response included).
+	OnCommand func(cmd string, dur time.Duration, err error)
+	// DefaultEventFormat is the events format (plain, json or xml) used by
+	// Subscribe, SubscribeAll and MyEvents when called with an empty format.
+	// It defaults to plain.
+	DefaultEventFormat string
+	// EventWorkers, if not zero, is the number of goroutines handling the
+	// events, which are queued (EventQueueSize events at most) until a worker
+	// is available. Otherwise each event is handled in its own goroutine.
+	// A single worker handles the events in order.
+	EventWorkers int
+	// EventQueueSize is the size of the EventWorkers queue.
+	// DefaultEventQueueSize is used if zero.
+	EventQueueSize int
+	// EventOverflow tells what to do with an event when the EventWorkers
+	// queue is full (DropOldest by default).
+	EventOverflow OverflowPolicy
+}
+
+// DefaultReadBufferSize is the default Connection.ReadBufferSize.
+const DefaultReadBufferSize = 16 * 1024
+
+// DefaultTCPKeepAlivePeriod is the default Connection.TCPKeepAlivePeriod.
+const DefaultTCPKeepAlivePeriod = 30 * time.Second
+
+// DefaultEventTimeout is the default Connection.EventTimeout.
+const DefaultEventTimeout = 5 * time.Second
+
+// DefaultPort is the freeswitch event socket port, used if Address has none.
+const DefaultPort = "8021"
+
+// NewConnection connects and authenticates to freeswitch at host. The caller
+// must then run HandleEvents, which reads the events and command replies and
+// starts by calling handler.OnConnect in a new goroutine: commands can be sent
+// from OnConnect (or once HandleEvents is running) but not before. The opts
+// are applied to the connection before connecting.
+func NewConnection(host string, handler ConnectionHandler, opts ...Option) (*Connection, error) {
+	con := newConnection(host, handler)
+	for _, opt := range opts {
+		opt(con)
+	}
+	return con.open()
+}
+
+// NewConnectionTLS is like NewConnection but connects to freeswitch over TLS
+// using cfg. A nil cfg means a plain TCP connection. See also WithTLS.
+func NewConnectionTLS(host string, cfg *tls.Config, handler ConnectionHandler) (*Connection, error) {
+	con := newConnection(host, handler)
+	con.TLSConfig = cfg
+	return con.open()
+}
+
+// NewConnectionWithPassword is like NewConnection but authenticates with
+// password instead of the default ClueCon. See also WithPassword.
+func NewConnectionWithPassword(host, password string, handler ConnectionHandler) (*Connection, error) {
+	con := newConnection(host, handler)
+	con.Password = password
+	return con.open()
+}
+
+// newConnection returns a connection to host with the default settings.
+func newConnection(host string, handler ConnectionHandler) *Connection {
+	return &Connection{
+		Address:        host,
+		Password:       "ClueCon",
+		Timeout:        3 * time.Second,
+		MaxRetries:     3,
+		ReadBufferSize: DefaultReadBufferSize,
+		Handler:        handler,
 	}
-	go con.Handler.OnConnect(&con)
-	return &con, nil
+}
+
+// open connects and authenticates con, returning it on success.
+func (con *Connection) open() (*Connection, error) {
+	if err := con.ConnectRetry(con.MaxRetries); err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	return con, nil
 }
 
 func (con *Connection) SendRecv(cmd string, args ...string) (*Event, error) {
+	return con.SendRecvContext(context.Background(), cmd, args...)
+}
+
+// SendRecvContext sends cmd with args and waits for the command reply.
+// It returns ctx.Err() if ctx is done before the reply is received.
+func (con *Connection) SendRecvContext(ctx context.Context, cmd string, args ...string) (*Event, error) {
 	buf := bytes.NewBufferString(cmd)
 	for _, arg := range args {
 		buf.WriteString(" ")
 		buf.WriteString(arg)
 	}
 	buf.WriteString("\n\n")
-	_, err := con.Write(buf.Bytes())
+	ev, err := con.exchange(ctx, buf.Bytes(), &con.cmdReplies)
+	if err == nil {
+		if reply := ev.Get("Reply-Text"); strings.HasPrefix(reply, "-ERR") {
+			ev, err = nil, &CommandError{Command: cmdString(cmd, args), Reply: strings.TrimSpace(reply)}
+		}
+	}
+	return ev, err
+}
+
+// commandDone passes the outcome of the command sent with w to OnCommand: err,
+// or the error of its reply ev.
+func (con *Connection) commandDone(w *replyWaiter, ev *Event, err error) {
+	if con.OnCommand == nil {
+		return
+	}
+	if err == nil && ev != nil {
+		if ev.Type == EventApiResponse {
+			err = apiError(w.cmd, string(ev.RawBody))
+		} else if reply := ev.Get("Reply-Text"); strings.HasPrefix(reply, "-ERR") {
+			err = &CommandError{Command: w.cmd, Reply: strings.TrimSpace(reply)}
+		}
+	}
+	con.OnCommand(w.cmd, w.rtt, err)
+}
+
+// commandName returns the name of the command written in b, for OnCommand: its
+// first word, with the second one for api and bgapi.
+func commandName(b []byte) string {
+	line, _, _ := strings.Cut(string(b), "\n")
+	words := strings.Fields(line)
+	switch {
+	case len(words) == 0:
+		return ""
+	case len(words) > 1 && (words[0] == "api" || words[0] == "bgapi"):
+		return words[0] + " " + words[1]
+	}
+	return words[0]
+}
+
+// Raw sends command as is and returns its command reply event, without
+// interpreting the reply. It is meant for the commands that are not wrapped by
+// the package and get a command/reply (not api, which gets an api/response).
+func (con *Connection) Raw(command string) (*Event, error) {
+	return con.exchange(context.Background(), []byte(command+"\n\n"), &con.cmdReplies)
+}
+
+// Subscribe subscribes to the events names in the given format (plain, json or xml).
+// An empty format means con.DefaultEventFormat.
+func (con *Connection) Subscribe(format string, names ...EventName) error {
+	if err := checkEventNames(names); err != nil {
+		return fmt.Errorf("event: %w", err)
+	}
+	format = con.eventFormat(format)
+	args := []string{format}
+	for _, name := range names {
+		args = append(args, name.String())
+	}
+	if err := con.sendOK("event", args...); err != nil {
+		return err
+	}
+	con.subsMu.Lock()
+	defer con.subsMu.Unlock()
+	con.subFormat = format
+	for _, name := range names {
+		if !hasEventName(con.subNames, name) {
+			con.subNames = append(con.subNames, name)
+		}
+	}
+	return nil
+}
+
+// SubscribeAll subscribes to all events in the given format, see Subscribe.
+func (con *Connection) SubscribeAll(format string) error {
+	return con.Subscribe(format, ALL)
+}
+
+// checkEventNames fails if a name of names is not a freeswitch event name
+// (e.g. EventNameUnknown).
+func checkEventNames(names []EventName) error {
+	for _, name := range names {
+		if name < CUSTOM || name > ALL {
+			return fmt.Errorf("%s can't be subscribed", name)
+		}
+	}
+	return nil
+}
+
+// eventFormat returns format, or con.DefaultEventFormat (plain if not set) if
+// format is empty.
+func (con *Connection) eventFormat(format string) string {
+	if format != "" {
+		return format
+	}
+	if con.DefaultEventFormat != "" {
+		return con.DefaultEventFormat
+	}
+	return "plain"
+}
+
+// Unsubscribe cancels the subscription to the events names.
+func (con *Connection) Unsubscribe(names ...EventName) error {
+	if err := checkEventNames(names); err != nil {
+		return fmt.Errorf("nixevent: %w", err)
+	}
+	args := make([]string, 0, len(names))
+	for _, name := range names {
+		args = append(args, name.String())
+	}
+	if err := con.sendOK("nixevent", args...); err != nil {
+		return err
+	}
+	con.subsMu.Lock()
+	defer con.subsMu.Unlock()
+	subNames := con.subNames[:0]
+	for _, name := range con.subNames {
+		if !hasEventName(names, name) {
+			subNames = append(subNames, name)
+		}
+	}
+	con.subNames = subNames
+	return nil
+}
+
+// resubscribe replays the current subscription after a reconnection.
+func (con *Connection) resubscribe() error {
+	con.subsMu.Lock()
+	format, names := con.subFormat, append([]EventName(nil), con.subNames...)
+	con.subsMu.Unlock()
+	if len(names) == 0 {
+		return nil
+	}
+	return con.Subscribe(format, names...)
+}
+
+func hasEventName(names []EventName, name EventName) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter adds an event filter: only the events whose header matches value are received.
+func (con *Connection) Filter(header, value string) error {
+	return con.sendOK("filter", header, value)
+}
+
+// FilterDelete removes the event filter on header/value.
+func (con *Connection) FilterDelete(header, value string) error {
+	return con.sendOK("filter delete", header, value)
+}
+
+// EnableLog asks freeswitch to send its log messages of the given level (a
+// name, e.g. "debug" or "warning", or a number from 0 to 7) and more severe,
+// as EventLog events named LOG.
+func (con *Connection) EnableLog(level string) error {
+	if level == "" {
+		return fmt.Errorf("log: empty level")
+	}
+	return con.sendOK("log", level)
+}
+
+// DisableLog stops the log messages enabled with EnableLog.
+func (con *Connection) DisableLog() error {
+	return con.sendOK("nolog")
+}
+
+// sendOK sends cmd with args and checks that the reply is +OK.
+func (con *Connection) sendOK(cmd string, args ...string) error {
+	ev, err := con.SendRecv(cmd, args...)
 	if err != nil {
-		return nil, fmt.Errorf("send bytes: %v", err)
+		return err
 	}
-	ev := <-con.cmdReply
-	reply := ev.Get("Reply-Text")
-	if strings.HasPrefix(reply, "-ERR") {
-		return nil, fmt.Errorf("SendRecv %s %s: %s", cmd, args, strings.TrimSpace(reply))
+	if reply := ev.Get("Reply-Text"); !strings.HasPrefix(reply, "+OK") {
+		return &CommandError{Command: cmdString(cmd, args), Reply: strings.TrimSpace(reply)}
 	}
-	return ev, nil
+	return nil
 }
 
+// FatalOnMustSendRecv restores the former MustSendRecv behavior of exiting the
+// program (after logging the error) instead of panicking.
+var FatalOnMustSendRecv = false
+
+// MustSendRecv is like SendRecv but closes the connection and panics with a
+// *CommandError if the command fails. Before panicking was introduced, it
+// used to exit the program: set FatalOnMustSendRecv to keep that behavior.
 func (con *Connection) MustSendRecv(cmd string, args ...string) *Event {
 	ev, err := con.SendRecv(cmd, args...)
 	if err != nil {
 		con.Close()
-		log.Fatal("ERR: ", err)
+		if FatalOnMustSendRecv {
+			con.logf("ERR: %v", err)
+			os.Exit(1)
+		}
+		cerr, ok := err.(*CommandError)
+		if !ok {
+			cerr = &CommandError{Command: cmdString(cmd, args), Err: err}
+		}
+		panic(cerr)
 	}
 	return ev
 }
@@ -89,33 +436,88 @@ func (con *Connection) SendEvent(cmd string, headers map[string]string, body []b
 	buf.WriteString(fmt.Sprintf("Content-Length: %d\n\n", len(body)))
 	buf.Write(body)
 
-	_, err := con.Write(buf.Bytes())
+	ev, err := con.exchange(context.Background(), buf.Bytes(), &con.cmdReplies)
 	if err != nil {
-		return nil, fmt.Errorf("send event: %v", err)
+		return nil, fmt.Errorf("send event: %w", err)
 	}
-	ev := <-con.cmdReply
 	reply := ev.Get("Reply-Text")
 	if strings.HasPrefix(reply, "-ERR") {
-		return nil, fmt.Errorf("send event %s: %s", cmd, strings.TrimSpace(reply))
+		return nil, &CommandError{Command: "sendevent " + cmd, Reply: strings.TrimSpace(reply)}
 	}
 	return ev, nil
 }
 
+// SendCustomEvent sends a CUSTOM event of the given subclass, with the
+// additional headers and body. The subclass is mandatory.
+func (con *Connection) SendCustomEvent(subclass string, headers map[string]string, body []byte) error {
+	if subclass == "" {
+		return fmt.Errorf("send custom event: empty subclass")
+	}
+	hdrs := make(map[string]string, len(headers)+2)
+	for k, v := range headers {
+		hdrs[k] = v
+	}
+	hdrs["Event-Name"] = "CUSTOM"
+	hdrs["Event-Subclass"] = subclass
+	_, err := con.SendEvent("CUSTOM", hdrs, body)
+	return err
+}
+
+// PublishPresence sends a PRESENCE_IN event for user (user@domain), e.g. to
+// drive BLF lamps: status is the presence text and state the dialog state
+// ("early", "confirmed" or "terminated"). The user is used as the dialog
+// unique-id, so that its successive states update the same dialog.
+func (con *Connection) PublishPresence(user, status, state string) error {
+	if !strings.Contains(user, "@") {
+		return fmt.Errorf("publish presence: user %q is not user@domain", user)
+	}
+	login, _, _ := strings.Cut(user, "@")
+	hdrs := map[string]string{
+		"proto":          "sip",
+		"login":          login,
+		"from":           user,
+		"status":         status,
+		"rpid":           "unknown",
+		"event_type":     "presence",
+		"alt_event_type": "dialog",
+		"event_count":    "1",
+		"unique-id":      user,
+		"answer-state":   state,
+	}
+	_, err := con.SendEvent("PRESENCE_IN", hdrs, nil)
+	return err
+}
+
 func (con *Connection) Api(cmd string, args ...string) (string, error) {
+	return con.api(context.Background(), cmd, args...)
+}
+
+// TryApi is like Api but returns ErrTimeout if the response is not received
+// within timeout. The late response is then dropped when it arrives.
+func (con *Connection) TryApi(timeout time.Duration, cmd string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, err := con.api(ctx, cmd, args...)
+	if err == context.DeadlineExceeded {
+		return "", ErrTimeout
+	}
+	return resp, err
+}
+
+// api sends the api command cmd with args and waits for its response until ctx is done.
+func (con *Connection) api(ctx context.Context, cmd string, args ...string) (string, error) {
 	buf := bytes.NewBufferString("api " + cmd)
 	for _, arg := range args {
 		buf.WriteString(" ")
 		buf.WriteString(arg)
 	}
 	buf.WriteString("\n\n")
-	_, err := con.Write(buf.Bytes())
-	if err != nil {
-		return "", fmt.Errorf("send bytes: %v", err)
+	ev, err := con.exchange(ctx, buf.Bytes(), &con.apiResponses)
+	if err == nil {
+		err = apiError(cmdString(cmd, args), string(ev.RawBody))
 	}
-	ev := <-con.apiResp
-	resp := strings.TrimSpace(string(ev.RawBody))
-	if strings.HasPrefix(resp, "-ERR") {
-		return "", fmt.Errorf("api %s %s: %s", cmd, args, resp)
+	if err != nil {
+		return "", err
 	}
 	return string(ev.RawBody), nil
 }
@@ -123,9 +525,9 @@ func (con *Connection) Api(cmd string, args ...string) (string, error) {
 func (con *Connection) BgApi(cmd string, args ...string) (string, error) {
 	repl, err := con.SendRecv("bgapi "+cmd, args...)
 	if err != nil {
-		return "", fmt.Errorf("bgapi: %v", err)
+		return "", fmt.Errorf("bgapi: %w", err)
 	}
-	return repl.Get("Job-Uuid"), nil
+	return repl.JobUUID(), nil
 }
 
 func (con *Connection) Execute(app string, uuid string, params ...string) (*Event, error) {
@@ -150,90 +552,662 @@ func (con *Connection) ExecuteSync(app string, uuid string, params ...string) (*
 	return cmd.Execute(con)
 }
 
+// ExecuteAsync executes app with params on channel uuid and returns a channel
+// receiving its CHANNEL_EXECUTE_COMPLETE event, correlated with the Event-UUID
+// sent with the command. The channel is closed without receiving anything if the
+// connection is lost first. CHANNEL_EXECUTE_COMPLETE events must be subscribed.
+func (con *Connection) ExecuteAsync(app string, uuid string, params ...string) (<-chan *Event, error) {
+	cmd := Command{
+		UId:       uuid,
+		App:       app,
+		Args:      strings.Join(params, " "),
+		EventUUID: newUUID(),
+	}
+	return con.executeAsync(cmd)
+}
+
+// executeAsync executes cmd and returns a channel receiving its
+// CHANNEL_EXECUTE_COMPLETE event, see ExecuteAsync.
+func (con *Connection) executeAsync(cmd Command) (<-chan *Event, error) {
+	w := con.addWaiter(cmd.UId, CHANNEL_EXECUTE_COMPLETE, func(ev *Event) bool {
+		return ev.Get("Application-UUID") == cmd.EventUUID
+	})
+	if _, err := cmd.Execute(con); err != nil {
+		con.removeWaiter(w)
+		return nil, err
+	}
+	done := make(chan *Event, 1)
+	go func() {
+		defer close(done)
+		if ev, err := con.await(context.Background(), w); err == nil {
+			done <- ev
+		}
+	}()
+	return done, nil
+}
+
+// ConnectRetry dials freeswitch, making at most MaxRetries attempts (at least
+// one), then authenticates.
 func (con *Connection) ConnectRetry(MaxRetries int) error {
-	for retries := 1; !con.Connected && retries <= MaxRetries; retries++ {
-		c, err := net.DialTimeout("tcp", con.Address, con.Timeout)
+	addr, err := normalizeAddress(con.Address)
+	if err != nil {
+		return &DialError{Address: con.Address, Err: err}
+	}
+	if addr != con.Address {
+		con.Address = addr
+	}
+	if MaxRetries < 1 {
+		MaxRetries = 1
+	}
+	con.setState(Connecting)
+	for retries := 1; !con.connected.Load() && retries <= MaxRetries; retries++ {
+		c, err := con.dial()
 		if err != nil {
 			if retries == MaxRetries {
-				return fmt.Errorf("last dial attempt: %v", err)
+				return &DialError{Address: con.Address, Err: err}
 			}
-			log.Printf("NOTICE: dial attempt #%d: %v, retrying\n", retries, err)
+			con.logf("NOTICE: dial attempt #%d: %v, retrying\n", retries, err)
 		} else {
-			con.socket = c
+			con.setKeepAlive(c)
+			con.setSocket(c)
 			break
 		}
 	}
-	con.buffer = bufio.NewReadWriter(bufio.NewReaderSize(con.socket, 16*1024),
-		bufio.NewWriter(con.socket))
 	return con.Authenticate()
 }
 
+// normalizeAddress returns addr as a host:port address, with DefaultPort if it
+// has no port. The host can be a bare or bracketed IPv6 literal.
+func normalizeAddress(addr string) (string, error) {
+	if addr == "" {
+		return "", fmt.Errorf("empty address")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// no port: bare host, bare IPv6 or bracketed IPv6
+		host = addr
+		if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+			host = addr[1 : len(addr)-1]
+		}
+		ip, _, _ := strings.Cut(host, "%") // IPv6 zone
+		if strings.ContainsAny(host, "[]") || (strings.Contains(host, ":") && net.ParseIP(ip) == nil) {
+			return "", fmt.Errorf("invalid address %q", addr)
+		}
+		port = ""
+	}
+	if port == "" {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// dial opens a TCP connection to con.Address, over TLS if con.TLSConfig is set.
+func (con *Connection) dial() (net.Conn, error) {
+	if con.TLSConfig != nil {
+		dialer := &net.Dialer{Timeout: con.Timeout}
+		return tls.DialWithDialer(dialer, "tcp", con.Address, con.TLSConfig)
+	}
+	return net.DialTimeout("tcp", con.Address, con.Timeout)
+}
+
+// setKeepAlive enables TCP keepalive on c as set by con.TCPKeepAlivePeriod.
+func (con *Connection) setKeepAlive(c net.Conn) {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	tcp, ok := c.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	period := con.TCPKeepAlivePeriod
+	if period == 0 {
+		period = DefaultTCPKeepAlivePeriod
+	}
+	if period < 0 {
+		tcp.SetKeepAlive(false)
+		return
+	}
+	if err := tcp.SetKeepAlive(true); err != nil {
+		con.logf("NOTICE: tcp keepalive: %v\n", err)
+		return
+	}
+	if err := tcp.SetKeepAlivePeriod(period); err != nil {
+		con.logf("NOTICE: tcp keepalive period: %v\n", err)
+	}
+}
+
+// readEvent reads the next event from the connection socket.
+func (con *Connection) readEvent() (*Event, error) {
+	maxBody := con.MaxBodySize
+	if maxBody == 0 {
+		maxBody = DefaultMaxBodySize
+	}
+	maxHeader := con.MaxHeaderBytes
+	if maxHeader == 0 {
+		maxHeader = DefaultMaxHeaderBytes
+	}
+	return readEvent(con.buffer.Reader, maxHeader, maxBody, con.detachBody, con.BodyPool)
+}
+
+// setSocket sets c as the connection socket and wires the read/write buffer on it.
+// It must be called from the goroutine reading the events (or before it starts).
+func (con *Connection) setSocket(c net.Conn) {
+	size := con.ReadBufferSize
+	if size == 0 {
+		size = DefaultReadBufferSize
+	}
+	buffer := bufio.NewReadWriter(bufio.NewReaderSize(c, size), bufio.NewWriter(c))
+	con.writeMu.Lock()
+	con.socketMu.Lock()
+	con.socket = c
+	con.buffer = buffer
+	con.socketMu.Unlock()
+	con.writeMu.Unlock()
+	con.lostMu.Lock()
+	con.lost = make(chan struct{})
+	con.lostMu.Unlock()
+	con.cmdReplies.reset()
+	con.apiResponses.reset()
+}
+
+// IsConnected tells if the connection is connected and authenticated.
+func (con *Connection) IsConnected() bool {
+	return con.connected.Load()
+}
+
+// Done returns a channel closed when the connection is lost or closed: by Close,
+// when HandleEvents returns, or when the connection is lost with AutoReconnect
+// set. In the latter case, Done returns a new channel once reconnected.
+func (con *Connection) Done() <-chan struct{} {
+	return con.lostChan()
+}
+
+// lostChan returns the channel closed when the current socket is lost.
+func (con *Connection) lostChan() chan struct{} {
+	con.lostMu.Lock()
+	defer con.lostMu.Unlock()
+	return con.lost
+}
+
+// signalLost wakes up the callers waiting for a reply on the current socket,
+// which then return ErrConnectionClosed. It may be called several times.
+func (con *Connection) signalLost() {
+	con.lostMu.Lock()
+	defer con.lostMu.Unlock()
+	select {
+	case <-con.lost:
+	default:
+		close(con.lost)
+	}
+}
+
 // Authenticate handles freeswitch esl authentication
 func (con *Connection) Authenticate() error {
-	ev, err := NewEventFromReader(con.buffer.Reader)
-	if err != nil || ev.Type != EventAuth {
-		con.socket.Close()
-		if ev.Type != EventAuth {
-			return fmt.Errorf("bad auth preamble: [%s]", ev.Header)
-		}
-		return fmt.Errorf("socket read error: %v", err)
+	ev, err := con.readEvent()
+	if err != nil {
+		con.conn().Close()
+		return &AuthError{Err: fmt.Errorf("socket read error: %w", err)}
+	}
+	if ev.Type != EventAuth {
+		con.conn().Close()
+		return &AuthError{Err: fmt.Errorf("bad auth preamble: [%s]", ev.Header)}
 	}
 
+	authCmd := con.AuthCommand
+	if authCmd == "" {
+		authCmd = "auth " + con.Password
+	}
 	var buf bytes.Buffer
-	buf.WriteString(fmt.Sprintf("auth %s\n\n", con.Password))
+	buf.WriteString(authCmd + "\n\n")
 	if _, err := con.Write(buf.Bytes()); err != nil {
-		con.socket.Close()
-		return fmt.Errorf("passwd buffer flush: %v", err)
+		con.conn().Close()
+		return &AuthError{Err: fmt.Errorf("passwd buffer flush: %w", err)}
 	}
 
-	ev, err = NewEventFromReader(con.buffer.Reader)
+	ev, err = con.readEvent()
 	if err != nil {
-		con.socket.Close()
-		return fmt.Errorf("auth reply: %v", err)
+		con.conn().Close()
+		return &AuthError{Err: fmt.Errorf("auth reply: %w", err)}
 	}
 	if ev.Type != EventCommandReply {
-		con.socket.Close()
-		return fmt.Errorf("bad reply type: %#v", ev.Type)
+		con.conn().Close()
+		return &AuthError{Err: fmt.Errorf("bad reply type: %#v", ev.Type)}
+	}
+	if reply := ev.Get("Reply-Text"); !strings.HasPrefix(reply, "+OK") {
+		con.conn().Close()
+		return &AuthError{Err: fmt.Errorf("auth rejected: %s", strings.TrimSpace(reply))}
 	}
-	con.Connected = true
+	con.connected.Store(true)
+	con.setState(Authenticated)
 	return nil
 }
 
+// HandleEvents reads and handles the connection events until the connection is
+// closed. It calls Handler.OnConnect in a new goroutine once started.
 func (con *Connection) HandleEvents() error {
-	for con.Connected {
-		ev, err := NewEventFromReader(con.buffer.Reader)
+	defer con.signalLost()
+	go con.safeCall("OnConnect", func() { con.Handler.OnConnect(con) })
+	con.lastEvent.Store(time.Now().UnixNano())
+	if con.HeartbeatTimeout > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go con.watchHeartbeat(stop)
+	}
+	if con.KeepAliveInterval > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go con.keepAlive(stop)
+	}
+	if con.EventWorkers > 0 {
+		con.startWorkers()
+		defer close(con.eventQueue)
+	}
+	for con.connected.Load() {
+		ev, err := con.readEvent()
+		con.lastEvent.Store(time.Now().UnixNano())
+		if err == ErrBodyTooLarge {
+			// the reply must still be paired with its waiter
+			switch ev.Type {
+			case EventCommandReply:
+				con.fail(&con.cmdReplies, err)
+			case EventApiResponse:
+				con.fail(&con.apiResponses, err)
+			}
+			con.logf("ERR: event dropped: %v: [%s]\n", err, ev.Header)
+			continue
+		}
 		if err != nil {
-			if err == io.EOF || !con.Connected {
+			expired := con.hbExpired.Swap(false)
+			if !con.closed.Load() {
+				con.setState(Disconnected)
+			}
+			if con.AutoReconnect && !con.closed.Load() && !con.rejected.Load() && !con.exiting.Load() {
+				con.signalLost()
+				con.logf("NOTICE: connection lost: %v, reconnecting\n", err)
+				if err := con.reconnect(); err != nil {
+					con.Close()
+					return err
+				}
+				continue
+			}
+			if expired {
+				con.Close()
+				return ErrHeartbeatTimeout
+			}
+			if err == io.EOF || err == ErrTruncatedBody || !con.connected.Load() {
+				// disconnected
 				return nil
 			}
 			con.Close()
-			return fmt.Errorf("event read loop: %v\n", err)
+			return fmt.Errorf("event read loop: %w\n", err)
 		}
 		switch ev.Type {
 		case EventError:
 			return fmt.Errorf("invalid event: [%s]", ev)
 		case EventDisconnect:
-			con.Handler.OnDisconnect(con, ev)
+			if ev.DisconnectReason == RudeRejection {
+				con.rejected.Store(true)
+			}
+			if con.exiting.Load() {
+				con.handlersMu.Lock()
+				if con.exitNotice != nil {
+					close(con.exitNotice)
+					con.exitNotice = nil
+				}
+				con.handlersMu.Unlock()
+			}
+			con.safeCall("OnDisconnect", func() { con.Handler.OnDisconnect(con, ev) })
 		case EventCommandReply:
-			con.cmdReply <- ev
+			con.deliver(&con.cmdReplies, ev)
 		case EventApiResponse:
-			con.apiResp <- ev
-		case EventGeneric:
-			go con.Handler.OnEvent(con, ev)
+			if ev.detached() {
+				con.streamBody(ev)
+				continue
+			}
+			con.deliver(&con.apiResponses, ev)
+		case EventGeneric, EventLog:
+			con.dispatch(ev)
 		}
 	}
 	return fmt.Errorf("disconnected")
 }
 
+// watchHeartbeat closes the socket, making the read loop fail, if no event is
+// received during con.HeartbeatTimeout. It returns when stop is closed.
+func (con *Connection) watchHeartbeat(stop chan struct{}) {
+	ticker := time.NewTicker(con.HeartbeatTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			last := time.Unix(0, con.lastEvent.Load())
+			if now.Sub(last) > con.HeartbeatTimeout {
+				con.logf("NOTICE: no event received since %v, closing socket\n", last)
+				con.lastEvent.Store(now.UnixNano())
+				con.hbExpired.Store(true)
+				con.conn().Close()
+				con.signalLost()
+			}
+		}
+	}
+}
+
+// keepAlive sends an api status command every con.KeepAliveInterval, until
+// stop is closed.
+func (con *Connection) keepAlive(stop chan struct{}) {
+	ticker := time.NewTicker(con.KeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := con.Api("status"); err != nil && !con.closed.Load() {
+				con.logf("NOTICE: keepalive: %v\n", err)
+			}
+		}
+	}
+}
+
+// deliver sends the reply ev to the first caller waiting in replies. The
+// events other than the queue reply type are dropped: they would shift the
+// pairing of all the following replies.
+func (con *Connection) deliver(replies *replyQueue, ev *Event) {
+	want := EventCommandReply
+	if replies == &con.apiResponses {
+		want = EventApiResponse
+	}
+	if ev.Type != want {
+		con.logf("ERR: %s delivered as %s, dropped: [%s]\n", ev.Type, want, ev.Header)
+		return
+	}
+	w := replies.pop()
+	if w == nil {
+		con.logf("ERR: unexpected reply dropped: [%s]\n", ev.Header)
+		return
+	}
+	w.ch <- ev
+}
+
+// fail fails the first caller waiting in replies with err, in place of its
+// reply.
+func (con *Connection) fail(replies *replyQueue, err error) {
+	if w := replies.pop(); w != nil {
+		w.err = err
+		w.ch <- nil
+	}
+}
+
+// reconnect reconnects to freeswitch with an exponential backoff, at most
+// con.MaxRetries times. On success, the subscription is replayed and
+// Handler.OnConnect is called in a new goroutine.
+func (con *Connection) reconnect() error {
+	con.connected.Store(false)
+	con.conn().Close()
+	delay := 500 * time.Millisecond
+	var err error
+	for retries := 1; retries <= con.MaxRetries; retries++ {
+		time.Sleep(delay)
+		if con.closed.Load() {
+			return fmt.Errorf("reconnect: connection closed")
+		}
+		if err = con.ConnectRetry(1); err == nil {
+			go func() {
+				if err := con.resubscribe(); err != nil {
+					con.logf("ERR: replay subscription: %v\n", err)
+				}
+				con.safeCall("OnConnect", func() { con.Handler.OnConnect(con) })
+			}()
+			return nil
+		}
+		con.logf("NOTICE: reconnect attempt #%d: %v\n", retries, err)
+		if delay *= 2; delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
+	}
+	return fmt.Errorf("reconnect: %w", err)
+}
+
+// replyWaiter is a caller waiting for a reply.
+type replyWaiter struct {
+	ch     chan *Event   // buffered, so that an abandoned reply is just dropped
+	cmd    string        // command name, for OnCommand
+	err    error         // set before sending nil on ch when the reply is unusable, see fail
+	stream bool          // ApiStream waiter, see detachBody
+	rtt    time.Duration // time from the write to the reply (or failure)
+	popped chan struct{} // if set, closed when popped, see FlushPending
+	gone   chan struct{} // if set, closed when the caller gives up waiting, see streamBody
+}
+
+// replyQueue is a FIFO queue of reply waiters.
+type replyQueue struct {
+	mu      sync.Mutex
+	waiters []*replyWaiter
+}
+
+func (q *replyQueue) push(w *replyWaiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.waiters = append(q.waiters, w)
+}
+
+// pop removes and returns the first waiter, or nil if the queue is empty.
+func (q *replyQueue) pop() *replyWaiter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) == 0 {
+		return nil
+	}
+	w := q.waiters[0]
+	q.waiters[0] = nil
+	q.waiters = q.waiters[1:]
+	if w.popped != nil {
+		close(w.popped)
+	}
+	return w
+}
+
+// tailPopped returns a channel closed once the last waiter, and so all the
+// waiters currently queued, are popped (or reset). It returns nil if the queue
+// is empty.
+func (q *replyQueue) tailPopped() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) == 0 {
+		return nil
+	}
+	w := q.waiters[len(q.waiters)-1]
+	if w.popped == nil {
+		w.popped = make(chan struct{})
+	}
+	return w.popped
+}
+
+// peek returns the first waiter, or nil if the queue is empty.
+func (q *replyQueue) peek() *replyWaiter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) == 0 {
+		return nil
+	}
+	return q.waiters[0]
+}
+
+// reset drops all the waiters, as their replies won't come anymore.
+func (q *replyQueue) reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, w := range q.waiters {
+		if w.popped != nil {
+			close(w.popped)
+		}
+	}
+	q.waiters = nil
+}
+
+// FlushPending waits for the replies to the commands already sent, including
+// the ones whose callers gave up (e.g. on timeout), to be received. The replies
+// are paired with their commands in order, so a late reply never reaches a
+// later command: FlushPending is only needed to make sure that nothing is in
+// flight, e.g. before handing the connection over. It returns ctx.Err() if ctx
+// is done first, or ErrConnectionClosed if the connection is lost.
+func (con *Connection) FlushPending(ctx context.Context) error {
+	lost := con.lostChan()
+	for _, q := range []*replyQueue{&con.cmdReplies, &con.apiResponses} {
+		popped := q.tailPopped()
+		if popped == nil {
+			continue
+		}
+		select {
+		case <-popped:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-lost:
+			return ErrConnectionClosed
+		}
+	}
+	return nil
+}
+
+// exchange writes b and waits for its reply, queued in replies. The waiter is
+// queued along with the write, so that concurrent callers get their replies in
+// the order their commands were written.
+// If ctx is done before the reply is received, ctx.Err() is returned (or
+// ErrTimeout if con.CommandTimeout is exceeded) and the late reply is dropped.
+func (con *Connection) exchange(ctx context.Context, b []byte, replies *replyQueue) (*Event, error) {
+	return con.exchangeWaiter(ctx, b, replies, &replyWaiter{ch: make(chan *Event, 1)})
+}
+
+// exchangeWaiter is exchange with the given waiter.
+func (con *Connection) exchangeWaiter(ctx context.Context, b []byte, replies *replyQueue, w *replyWaiter) (*Event, error) {
+	w.cmd = commandName(b)
+	lost := con.lostChan()
+	con.writeMu.Lock()
+	replies.push(w)
+	_, err := con.write(b)
+	sent := time.Now()
+	con.writeMu.Unlock()
+	if err != nil {
+		err = &SendError{Err: err}
+		con.commandDone(w, nil, err)
+		return nil, err
+	}
+	return con.waitReply(ctx, w, sent, lost)
+}
+
+// waitReply waits for the reply of w, sent at the given time, until ctx is
+// done, con.CommandTimeout expires or the connection is lost. The outcome is
+// passed to OnCommand.
+func (con *Connection) waitReply(ctx context.Context, w *replyWaiter, sent time.Time, lost <-chan struct{}) (ev *Event, err error) {
+	var timeout <-chan time.Time
+	if con.CommandTimeout > 0 {
+		timer := time.NewTimer(con.CommandTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	defer func() {
+		w.rtt = time.Since(sent)
+		con.commandDone(w, ev, err)
+	}()
+	select {
+	case ev := <-w.ch:
+		if ev == nil {
+			return nil, w.err
+		}
+		return ev, nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrTimeout
+	case <-lost:
+		err = ErrConnectionClosed
+	}
+	if w.gone != nil {
+		close(w.gone)
+	}
+	return nil, err
+}
+
+// Conn returns the underlying socket, e.g. to set deadlines or TCP options.
+// Reading from or writing to it directly conflicts with HandleEvents and the
+// command methods: only its deadline and option setters are safe to use.
+func (con *Connection) Conn() net.Conn {
+	return con.conn()
+}
+
+// conn returns the current socket.
+func (con *Connection) conn() net.Conn {
+	con.socketMu.Lock()
+	defer con.socketMu.Unlock()
+	return con.socket
+}
+
+// Write writes b to the connection socket and flushes it. Writes are serialized
+// but Write doesn't wait for any reply: use the command methods for that.
 func (con *Connection) Write(b []byte) (int, error) {
+	con.writeMu.Lock()
+	defer con.writeMu.Unlock()
+	return con.write(b)
+}
+
+// write writes b to the connection socket and flushes it. con.writeMu must be held.
+func (con *Connection) write(b []byte) (int, error) {
 	defer con.buffer.Flush()
 	return con.buffer.Write(b)
 }
 
 func (con *Connection) Close() {
-	if con.Connected {
-		con.Connected = false
-		con.Handler.OnClose(con)
+	if !con.closed.Swap(true) {
+		con.setState(Closed)
+	}
+	if con.connected.Swap(false) {
+		con.safeCall("OnClose", func() { con.Handler.OnClose(con) })
+	}
+	con.conn().Close()
+	con.signalLost()
+}
+
+// Exit ends the session cleanly: it sends the exit command, waits (at most
+// con.EventTimeout) for the freeswitch disconnect notice, then closes the
+// connection. HandleEvents must be running.
+func (con *Connection) Exit() error {
+	con.exiting.Store(true)
+	notice := make(chan struct{})
+	con.handlersMu.Lock()
+	con.exitNotice = notice
+	con.handlersMu.Unlock()
+	err := con.sendOK("exit")
+	if err == nil {
+		timer := time.NewTimer(con.eventTimeout())
+		defer timer.Stop()
+		select {
+		case <-notice:
+		case <-con.lostChan():
+		case <-timer.C:
+			err = fmt.Errorf("exit: no disconnect notice: %w", ErrTimeout)
+		}
+	}
+	con.Close()
+	return err
+}
+
+// eventTimeout returns con.EventTimeout, or DefaultEventTimeout if zero.
+func (con *Connection) eventTimeout() time.Duration {
+	if con.EventTimeout > 0 {
+		return con.EventTimeout
+	}
+	return DefaultEventTimeout
+}
+
+// newUUID returns a random (version 4) uuid.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("esl: read random: " + err.Error())
 	}
-	con.socket.Close()
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
 }
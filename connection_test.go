@@ -0,0 +1,634 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testHandler records the connection callbacks.
+type testHandler struct {
+	connected chan *Connection
+	events    chan *Event
+}
+
+func newTestHandler() *testHandler {
+	return &testHandler{
+		connected: make(chan *Connection, 1),
+		events:    make(chan *Event, 100),
+	}
+}
+
+func (h *testHandler) OnConnect(con *Connection) {
+	select {
+	case h.connected <- con:
+	default:
+	}
+}
+
+func (h *testHandler) OnEvent(con *Connection, ev *Event) {
+	select {
+	case h.events <- ev:
+	default:
+	}
+}
+
+func (h *testHandler) OnDisconnect(con *Connection, ev *Event) {}
+func (h *testHandler) OnClose(con *Connection)                 {}
+
+// fakeServer is the freeswitch end of a test connection.
+type fakeServer struct {
+	c net.Conn
+	r *bufio.Reader
+}
+
+// readCmd reads the next command, without its final empty line. It returns an
+// empty string once the connection is closed.
+func (s *fakeServer) readCmd() string {
+	cmd, _ := s.nextCmd()
+	return cmd
+}
+
+// nextCmd is readCmd also returning the read error.
+func (s *fakeServer) nextCmd() (string, error) {
+	var lines []string
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if line == "\n" {
+			return strings.Join(lines, "\n"), nil
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+}
+
+func (s *fakeServer) send(format string, args ...interface{}) {
+	fmt.Fprintf(s.c, format, args...)
+}
+
+func (s *fakeServer) reply(text string) {
+	s.send("Content-Type: command/reply\nReply-Text: %s\n\n", text)
+}
+
+func (s *fakeServer) apiResponse(body string) {
+	s.send("Content-Type: api/response\nContent-Length: %d\n\n%s", len(body), body)
+}
+
+// event sends a plain event with the given body headers.
+func (s *fakeServer) event(body string) {
+	s.send("Content-Length: %d\nContent-Type: text/event-plain\n\n%s", len(body), body)
+}
+
+// serve answers each command with reply until the connection is closed.
+func (s *fakeServer) serve(reply func(cmd string)) {
+	go func() {
+		for {
+			cmd, err := s.nextCmd()
+			if err != nil {
+				return
+			}
+			if cmd != "" { // blank line after a sendmsg
+				reply(cmd)
+			}
+		}
+	}()
+}
+
+// pipeConnection returns a connection authenticated over a net.Pipe, and the
+// fake freeswitch end of the pipe. Both are closed at the end of the test.
+func pipeConnection(t testing.TB, handler ConnectionHandler) (*Connection, *fakeServer) {
+	client, server := net.Pipe()
+	s := &fakeServer{c: server, r: bufio.NewReader(server)}
+	con := newConnection("pipe", handler)
+	con.setSocket(client)
+	auth := make(chan string, 1)
+	go func() {
+		s.send("Content-Type: auth/request\n\n")
+		auth <- s.readCmd()
+		s.reply("+OK accepted")
+	}()
+	if err := con.Authenticate(); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if cmd := <-auth; cmd != "auth ClueCon" {
+		t.Fatalf("auth command: got %q", cmd)
+	}
+	t.Cleanup(func() {
+		con.Close()
+		server.Close()
+	})
+	return con, s
+}
+
+// listenFake listens on a local port and serves each accepted connection as
+// a fake freeswitch with handle, which starts with the authentication. The
+// listener is closed at the end of the test.
+func listenFake(t *testing.T, handle func(s *fakeServer)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s := &fakeServer{c: c, r: bufio.NewReader(c)}
+			go func() {
+				defer c.Close()
+				handle(s)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// auth runs the authentication handshake, replying reply to the auth command.
+func (s *fakeServer) auth(reply string) {
+	s.send("Content-Type: auth/request\n\n")
+	s.readCmd()
+	s.reply(reply)
+}
+
+// handleEvents runs con.HandleEvents in the background and returns a channel
+// receiving its result.
+func handleEvents(con *Connection) <-chan error {
+	res := make(chan error, 1)
+	go func() { res <- con.HandleEvents() }()
+	return res
+}
+
+const statusOutput = `UP 0 years, 2 days, 3 hours, 4 minutes, 5 seconds, 678 milliseconds, 901 microseconds
+FreeSWITCH (Version 1.10.7 -release 64bit) is ready
+1234 session(s) since startup
+5 session(s) - peak 42, last 5min 7
+2 session(s) per Sec out of max 30, peak 12, last 5min 3
+1000 session(s) max
+min idle cpu 0.00/97.67
+Current Stack Size/Max 240K/8192K
+`
+
+func TestApiConcurrent(t *testing.T) {
+	con, s := pipeConnection(t, newTestHandler())
+	n := 0
+	s.serve(func(cmd string) {
+		if cmd != "api status" {
+			s.apiResponse("-ERR unexpected command " + cmd)
+			return
+		}
+		// each response is unique, to detect a response given twice
+		n++
+		s.apiResponse(fmt.Sprintf("%s%d status request(s)\n", statusOutput, n))
+	})
+	handleEvents(con)
+
+	const calls = 50
+	var wg sync.WaitGroup
+	resps := make(chan string, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := con.Api("status")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := parseStatus(resp); err != nil {
+				t.Error(err)
+				return
+			}
+			resps <- resp
+		}()
+	}
+	wg.Wait()
+	close(resps)
+	seen := make(map[string]bool)
+	for resp := range resps {
+		if seen[resp] {
+			t.Errorf("response received twice: %q", resp)
+		}
+		seen[resp] = true
+	}
+	if len(seen) != calls {
+		t.Errorf("got %d responses, want %d", len(seen), calls)
+	}
+}
+
+// apiOnConnect calls the status api from OnConnect, as applications do.
+type apiOnConnect struct {
+	testHandler
+	resp chan string
+}
+
+func (h *apiOnConnect) OnConnect(con *Connection) {
+	resp, err := con.Api("status")
+	if err != nil {
+		resp = "error: " + err.Error()
+	}
+	h.resp <- resp
+}
+
+func TestApiOnConnect(t *testing.T) {
+	h := &apiOnConnect{testHandler: *newTestHandler(), resp: make(chan string, 1)}
+	con, s := pipeConnection(t, h)
+	s.serve(func(cmd string) { s.apiResponse(statusOutput) })
+	handleEvents(con)
+	select {
+	case resp := <-h.resp:
+		if resp != statusOutput {
+			t.Errorf("got %q, want the status output", resp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no api response")
+	}
+}
+
+func TestAuthenticateClosed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string // sent before closing
+	}{
+		{"empty", ""},
+		{"truncated", "Content-Type: auth/req"},
+		{"after preamble", "Content-Type: auth/request\n\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			go func() {
+				if tc.data != "" {
+					server.Write([]byte(tc.data))
+				}
+				// drain the auth command, if any
+				go bufio.NewReader(server).ReadString('\n')
+				time.Sleep(10 * time.Millisecond)
+				server.Close()
+			}()
+			con := newConnection("pipe", newTestHandler())
+			con.setSocket(client)
+			err := con.Authenticate()
+			var authErr *AuthError
+			if !errors.As(err, &authErr) {
+				t.Fatalf("got %v, want an *AuthError", err)
+			}
+			if con.IsConnected() {
+				t.Error("connected after a failed authentication")
+			}
+		})
+	}
+}
+
+func TestGenericEventNotReply(t *testing.T) {
+	h := newTestHandler()
+	con, s := pipeConnection(t, h)
+	s.serve(func(cmd string) {
+		// an event sent before the reply must not be taken for it
+		s.event("Event-Name: HEARTBEAT\nReply-Text: %2BOK%20fake\n\n")
+		s.reply("+OK event listener enabled plain")
+	})
+	handleEvents(con)
+	ev, err := con.SendRecv("event", "plain", "HEARTBEAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != EventCommandReply || ev.Get("Reply-Text") != "+OK event listener enabled plain" {
+		t.Errorf("got reply %s [%s]", ev.Type, ev.Header)
+	}
+	select {
+	case ev := <-h.events:
+		if ev.Name != HEARTBEAT {
+			t.Errorf("got event %s, want HEARTBEAT", ev.Name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not dispatched")
+	}
+}
+
+func TestDeliverDropsOtherTypes(t *testing.T) {
+	con := newConnection("pipe", newTestHandler())
+	w := &replyWaiter{ch: make(chan *Event, 1)}
+	con.cmdReplies.push(w)
+	for _, typ := range []EventType{EventGeneric, EventLog, EventApiResponse, EventDisconnect} {
+		con.deliver(&con.cmdReplies, &Event{Type: typ})
+	}
+	if len(w.ch) != 0 || con.cmdReplies.peek() != w {
+		t.Fatal("non command reply delivered to the command reply waiter")
+	}
+	reply := &Event{Type: EventCommandReply}
+	con.deliver(&con.cmdReplies, reply)
+	if got := <-w.ch; got != reply {
+		t.Errorf("got %v, want the command reply", got)
+	}
+}
+
+func TestBodyTooLargeKeepsPairing(t *testing.T) {
+	con, s := pipeConnection(t, newTestHandler())
+	con.MaxBodySize = 10
+	con.CommandTimeout = time.Second
+	s.serve(func(cmd string) {
+		switch cmd {
+		case "api first":
+			s.apiResponse("a response larger than MaxBodySize")
+		case "event plain ALL":
+			s.send("Content-Type: command/reply\nContent-Length: 20\n\n01234567890123456789")
+		default:
+			s.apiResponse(cmd)
+		}
+	})
+	handleEvents(con)
+	if _, err := con.Api("first"); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("first: got %v, want ErrBodyTooLarge", err)
+	}
+	if _, err := con.SendRecv("event plain ALL"); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("event: got %v, want ErrBodyTooLarge", err)
+	}
+	if resp, err := con.Api("second"); err != nil || resp != "api second" {
+		t.Errorf("second: got %q, %v", resp, err)
+	}
+	replies, err := con.Pipeline().Api("first").Api("third").Exec(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(replies[0].Err, ErrBodyTooLarge) || string(replies[1].Event.RawBody) != "api third" {
+		t.Errorf("pipeline: got %v, %v", replies[0].Err, replies[1].Event)
+	}
+}
+
+// outboundConnection returns an outbound connection over a net.Pipe, for the
+// channel uuid, and the fake freeswitch end of the pipe.
+func outboundConnection(t testing.TB, handler ConnectionHandler, uuid string) (*Connection, *fakeServer) {
+	client, server := net.Pipe()
+	s := &fakeServer{c: server, r: bufio.NewReader(server)}
+	go func() {
+		if cmd := s.readCmd(); cmd != "connect" {
+			server.Close()
+			return
+		}
+		s.send("Content-Type: command/reply\nReply-Text: +OK\nUnique-ID: %s\nEvent-Name: CHANNEL_DATA\n\n", uuid)
+	}()
+	con, err := NewOutboundConnection(client, handler)
+	if err != nil {
+		t.Fatalf("outbound connection: %v", err)
+	}
+	t.Cleanup(func() {
+		con.Close()
+		server.Close()
+	})
+	return con, s
+}
+
+func TestExitOutbound(t *testing.T) {
+	con, s := outboundConnection(t, newTestHandler(), "1234")
+	s.serve(func(cmd string) {
+		s.reply("+OK bye")
+		time.Sleep(20 * time.Millisecond)
+		s.send("Content-Type: text/disconnect-notice\nContent-Length: 0\n\n")
+		s.c.Close()
+	})
+	res := handleEvents(con)
+	if err := con.Exit(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-res:
+	case <-time.After(time.Second):
+		t.Fatal("HandleEvents still running after Exit")
+	}
+}
+
+func TestConnectNoRetries(t *testing.T) {
+	addr := listenFake(t, func(s *fakeServer) {
+		s.auth("+OK accepted")
+		s.readCmd()
+	})
+	for _, n := range []int{0, -1} {
+		con, err := NewConnection(addr, newTestHandler(), WithMaxRetries(n))
+		if err != nil {
+			t.Fatalf("max retries %d: %v", n, err)
+		}
+		con.Close()
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	_, err = NewConnection(ln.Addr().String(), newTestHandler(), WithMaxRetries(0))
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) {
+		t.Errorf("got %v, want a *DialError", err)
+	}
+}
+
+func TestSubscribeUnknown(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  EventName
+	}{
+		{"HEARTBEAT", HEARTBEAT},
+		{"ALL", ALL},
+		{"EventNameUnknown", EventNameUnknown},
+		{"BOGUS", EventNameUnknown},
+		{"", EventNameUnknown},
+	} {
+		if got := eventName(tc.value); got != tc.want {
+			t.Errorf("eventName(%q): got %s, want %s", tc.value, got, tc.want)
+		}
+	}
+	con, s := pipeConnection(t, newTestHandler())
+	cmds := make(chan string, 4)
+	s.serve(func(cmd string) {
+		cmds <- cmd
+		s.reply("+OK")
+	})
+	handleEvents(con)
+	if err := con.Subscribe("plain", HEARTBEAT, EventNameUnknown); err == nil {
+		t.Error("Subscribe: got no error")
+	}
+	if err := con.Unsubscribe(EventNameUnknown); err == nil {
+		t.Error("Unsubscribe: got no error")
+	}
+	if err := con.Subscribe("plain", ALL); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := <-cmds; cmd != "event plain ALL" {
+		t.Errorf("got command %q, want only the valid subscription", cmd)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	var accepted atomic.Int32
+	addr := listenFake(t, func(s *fakeServer) {
+		s.auth("+OK accepted")
+		if accepted.Add(1) == 1 {
+			// the first connection is lost
+			return
+		}
+		for cmd := s.readCmd(); cmd != ""; cmd = s.readCmd() {
+			s.apiResponse(strings.TrimPrefix(cmd, "api "))
+		}
+	})
+	con, err := NewConnection(addr, newTestHandler(), WithAutoReconnect(), WithMaxRetries(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+	con.CommandTimeout = 100 * time.Millisecond
+	handleEvents(con)
+	// the socket is replaced while it is used
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				con.Conn().SetDeadline(time.Time{})
+				con.Api("busy")
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if resp, err := con.Api("status"); err == nil && resp == "status" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not reconnected")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := accepted.Load(); n != 2 {
+		t.Errorf("got %d connections, want 2", n)
+	}
+}
+
+func TestOnCommand(t *testing.T) {
+	con, s := pipeConnection(t, newTestHandler())
+	type call struct {
+		cmd string
+		err error
+	}
+	calls := make(chan call, 20)
+	con.OnCommand = func(cmd string, dur time.Duration, err error) {
+		calls <- call{cmd, err}
+	}
+	s.serve(func(cmd string) {
+		switch {
+		case cmd == "api bad":
+			s.apiResponse("-ERR bad command")
+		case strings.HasPrefix(cmd, "api "):
+			s.apiResponse("+OK")
+		case strings.HasPrefix(cmd, "bgapi "):
+			s.reply("+OK Job-UUID: 1234")
+		default:
+			s.reply("+OK")
+		}
+	})
+	handleEvents(con)
+	for _, tc := range []struct {
+		want []string
+		fn   func() error
+	}{
+		{[]string{"event"}, func() error {
+			_, err := con.SendRecv("event", "plain", "ALL")
+			return err
+		}},
+		{[]string{"api status"}, func() error {
+			_, err := con.Api("status")
+			return err
+		}},
+		{[]string{"sendmsg"}, func() error {
+			_, err := con.Execute("answer", "1234", "")
+			return err
+		}},
+		{[]string{"sendevent"}, func() error {
+			_, err := con.SendEvent("CUSTOM", map[string]string{"Event-Subclass": "test"}, nil)
+			return err
+		}},
+		{[]string{"api version", "noevents"}, func() error {
+			_, err := con.Pipeline().Api("version").SendRecv("noevents").Exec(context.Background())
+			return err
+		}},
+		{[]string{"api show"}, func() error {
+			r, err := con.ApiStream("show", "channels")
+			if err == nil {
+				r.Close()
+			}
+			return err
+		}},
+		{[]string{"bgapi status"}, func() error {
+			_, err := con.BgApiJob(context.Background(), "status")
+			return err
+		}},
+	} {
+		if err := tc.fn(); err != nil {
+			t.Fatalf("%v: %v", tc.want, err)
+		}
+		for _, want := range tc.want {
+			if c := <-calls; c.cmd != want || c.err != nil {
+				t.Errorf("got OnCommand(%q, %v), want %q", c.cmd, c.err, want)
+			}
+		}
+	}
+	if _, err := con.Api("bad"); err == nil {
+		t.Fatal("api bad: got no error")
+	}
+	var apiErr *APIError
+	if c := <-calls; c.cmd != "api bad" || !errors.As(c.err, &apiErr) {
+		t.Errorf("got OnCommand(%q, %v), want an *APIError", c.cmd, c.err)
+	}
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		want string // empty if invalid
+	}{
+		{"fs1", "fs1:8021"},
+		{"fs1:9000", "fs1:9000"},
+		{"127.0.0.1", "127.0.0.1:8021"},
+		{"::1", "[::1]:8021"},
+		{"[::1]", "[::1]:8021"},
+		{"[::1]:9000", "[::1]:9000"},
+		{"[fe80::1%eth0]", "[fe80::1%eth0]:8021"},
+		{"", ""},
+		{"[::1", ""},
+	} {
+		got, err := normalizeAddress(tc.addr)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%q: got %q, want an error", tc.addr, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%q: got %q, %v, want %q", tc.addr, got, err, tc.want)
+		}
+	}
+}
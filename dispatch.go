@@ -0,0 +1,248 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"context"
+	"runtime/debug"
+)
+
+// EventFunc is an event handler function.
+type EventFunc func(con *Connection, ev *Event)
+
+// OnSubclass registers fn as the handler of the CUSTOM events of the given
+// subclass (e.g. "sofia::register"), replacing any previous one. These events
+// are then passed to fn instead of Handler.OnEvent.
+func (con *Connection) OnSubclass(subclass string, fn EventFunc) {
+	con.handlersMu.Lock()
+	defer con.handlersMu.Unlock()
+	if con.subclassHandlers == nil {
+		con.subclassHandlers = make(map[string]EventFunc)
+	}
+	con.subclassHandlers[subclass] = fn
+}
+
+// On registers fn as a handler of the events name. The handlers of an event are
+// called in their registration order, followed by the OnAny handlers, instead
+// of Handler.OnEvent, which gets the events without handler.
+func (con *Connection) On(name EventName, fn EventFunc) {
+	con.handlersMu.Lock()
+	defer con.handlersMu.Unlock()
+	if con.nameHandlers == nil {
+		con.nameHandlers = make(map[EventName][]EventFunc)
+	}
+	con.nameHandlers[name] = append(con.nameHandlers[name], fn)
+}
+
+// OnAny registers fn as a handler of all the events, called after the handlers
+// registered with On or OnSubclass and before Handler.OnEvent.
+func (con *Connection) OnAny(fn EventFunc) {
+	con.handlersMu.Lock()
+	defer con.handlersMu.Unlock()
+	con.anyHandlers = append(con.anyHandlers, fn)
+}
+
+// waitKey identifies the events awaited by an eventWaiter.
+type waitKey struct {
+	uuid string
+	name EventName
+}
+
+// eventWaiter is a one-shot waiter for the first event matching its key (and
+// its match function, if any).
+type eventWaiter struct {
+	key   waitKey
+	match func(*Event) bool
+	ch    chan *Event
+}
+
+// WaitEvent waits for the first event name of the channel uuid and returns it,
+// or ctx.Err() if ctx is done before. The events are still passed to the
+// handlers.
+func (con *Connection) WaitEvent(ctx context.Context, uuid string, name EventName) (*Event, error) {
+	return con.await(ctx, con.addWaiter(uuid, name, nil))
+}
+
+// addWaiter registers a waiter for the event name of the channel uuid, for
+// which match, if not nil, returns true. It must be awaited with con.await.
+func (con *Connection) addWaiter(uuid string, name EventName, match func(*Event) bool) *eventWaiter {
+	w := &eventWaiter{key: waitKey{uuid, name}, match: match, ch: make(chan *Event, 1)}
+	con.handlersMu.Lock()
+	defer con.handlersMu.Unlock()
+	if con.waiters == nil {
+		con.waiters = make(map[waitKey][]*eventWaiter)
+	}
+	con.waiters[w.key] = append(con.waiters[w.key], w)
+	return w
+}
+
+// removeWaiter unregisters w, if still registered.
+func (con *Connection) removeWaiter(w *eventWaiter) {
+	con.handlersMu.Lock()
+	defer con.handlersMu.Unlock()
+	waiters := con.waiters[w.key]
+	for i, ww := range waiters {
+		if ww == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(con.waiters, w.key)
+	} else {
+		con.waiters[w.key] = waiters
+	}
+}
+
+// await waits for the event of w, which is unregistered on return.
+func (con *Connection) await(ctx context.Context, w *eventWaiter) (*Event, error) {
+	defer con.removeWaiter(w)
+	select {
+	case ev := <-w.ch:
+		return ev, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-con.lostChan():
+		return nil, ErrConnectionClosed
+	}
+}
+
+// notifyWaiters sends ev to its matching waiters, which are unregistered. It
+// tells if ev was sent to any.
+func (con *Connection) notifyWaiters(ev *Event) bool {
+	key := waitKey{ev.UId, ev.Name}
+	con.handlersMu.Lock()
+	defer con.handlersMu.Unlock()
+	waiters := con.waiters[key]
+	if len(waiters) == 0 {
+		return false
+	}
+	sent := false
+	remaining := waiters[:0]
+	for _, w := range waiters {
+		if w.match == nil || w.match(ev) {
+			w.ch <- ev
+			sent = true
+		} else {
+			remaining = append(remaining, w)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(con.waiters, key)
+	} else {
+		con.waiters[key] = remaining
+	}
+	return sent
+}
+
+// dispatch passes the generic event ev, in a new goroutine, to its registered
+// handlers, then to Handler.OnEvent if it has no subclass or name handler.
+func (con *Connection) dispatch(ev *Event) {
+	kept := con.dispatchJob(ev)
+	con.feedSession(ev)
+	kept = con.notifyWaiters(ev) || kept
+	var fns []EventFunc
+	con.handlersMu.Lock()
+	if ev.Name == CUSTOM && ev.Subclass != "" {
+		if fn := con.subclassHandlers[ev.Subclass]; fn != nil {
+			fns = append(fns, fn)
+		}
+	}
+	if len(fns) == 0 {
+		fns = append(fns, con.nameHandlers[ev.Name]...)
+	}
+	catchAll := len(fns) == 0
+	fns = append(fns, con.anyHandlers...)
+	con.handlersMu.Unlock()
+	handlers := len(fns)
+	if catchAll {
+		handlers++
+	}
+	ev.share(handlers, kept)
+	con.run(func() {
+		for _, fn := range fns {
+			con.safeCall("event", func() { fn(con, ev) })
+		}
+		if catchAll {
+			con.safeCall("OnEvent", func() { con.Handler.OnEvent(con, ev) })
+		}
+	})
+}
+
+// OverflowPolicy is the policy applied to the events received while the
+// EventWorkers queue is full. With BlockWhenFull, the command replies are not
+// read either while the read loop is blocked: handlers waiting for a command
+// reply with all the workers busy would deadlock.
+type OverflowPolicy int
+
+const (
+	DropOldest    OverflowPolicy = iota // drop the oldest queued event
+	DropNewest                          // drop the received event
+	BlockWhenFull                       // wait for room, blocking the read loop
+)
+
+// DefaultEventQueueSize is the default Connection.EventQueueSize.
+const DefaultEventQueueSize = 1024
+
+// startWorkers starts the EventWorkers goroutines, which run the jobs of
+// con.eventQueue until it is closed.
+func (con *Connection) startWorkers() {
+	size := con.EventQueueSize
+	if size == 0 {
+		size = DefaultEventQueueSize
+	}
+	con.eventQueue = make(chan func(), size)
+	for i := 0; i < con.EventWorkers; i++ {
+		go func(jobs <-chan func()) {
+			for job := range jobs {
+				job()
+			}
+		}(con.eventQueue)
+	}
+}
+
+// run runs the event handling job in a new goroutine or, with EventWorkers,
+// queues it according to con.EventOverflow.
+func (con *Connection) run(job func()) {
+	if con.eventQueue == nil {
+		go job()
+		return
+	}
+	select {
+	case con.eventQueue <- job:
+		return
+	default:
+	}
+	switch con.EventOverflow {
+	case BlockWhenFull:
+		con.eventQueue <- job
+		return
+	case DropOldest:
+		select {
+		case <-con.eventQueue:
+		default:
+		}
+		select {
+		case con.eventQueue <- job:
+			return
+		default:
+		}
+	}
+	con.logf("ERR: event queue full, event dropped\n")
+}
+
+// safeCall calls the handler fn, recovering and logging its panic, if any, and
+// passing it to con.OnHandlerPanic.
+func (con *Connection) safeCall(name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			con.logf("ERR: %s handler panic: %v\n%s", name, r, debug.Stack())
+			if con.OnHandlerPanic != nil {
+				con.OnHandlerPanic(r)
+			}
+		}
+	}()
+	fn()
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrTimeout is returned when a reply is not received in time.
+	ErrTimeout = errors.New("esl: timeout waiting for reply")
+	// ErrConnectionClosed is returned to the callers waiting for a reply when
+	// the connection is closed or lost.
+	ErrConnectionClosed = errors.New("esl: connection closed")
+	// ErrHeartbeatTimeout is returned by HandleEvents when no event is
+	// received during Connection.HeartbeatTimeout.
+	ErrHeartbeatTimeout = errors.New("esl: heartbeat timeout")
+	// ErrBodyTooLarge is returned when an event body is larger than the
+	// maximum body size. The body is skipped.
+	ErrBodyTooLarge = errors.New("esl: event body too large")
+	// ErrTruncatedBody is returned when the connection is closed in the
+	// middle of an event body. It wraps io.ErrUnexpectedEOF.
+	ErrTruncatedBody = fmt.Errorf("esl: truncated event body: %w", io.ErrUnexpectedEOF)
+	// ErrHeaderTooLarge is returned when an event header section is larger
+	// than the maximum header size. The stream can't be resynchronized.
+	ErrHeaderTooLarge = errors.New("esl: event header too large")
+	// ErrNoSuchChannel is returned by channel helpers when freeswitch reports
+	// that the channel doesn't exist (anymore).
+	ErrNoSuchChannel = errors.New("esl: no such channel")
+	// ErrInvalidExtension is returned by the transfer helpers when the
+	// destination extension is malformed or rejected by freeswitch.
+	ErrInvalidExtension = errors.New("esl: invalid extension")
+)
+
+// CommandError is returned (or used as panic value by MustSendRecv) when an
+// esl command fails.
+type CommandError struct {
+	Command string // command and its arguments
+	Reply   string // Reply-Text of the command reply, if any
+	Err     error  // underlying error, if any
+}
+
+func (e *CommandError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Command, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Command, e.Reply)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// SendError is returned when a command can't be written to the socket:
+// freeswitch didn't receive it.
+type SendError struct {
+	Err error
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("send bytes: %v", e.Err)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// DialError is returned when freeswitch can't be reached.
+type DialError struct {
+	Address string
+	Err     error
+}
+
+func (e *DialError) Error() string {
+	return fmt.Sprintf("dial %s: %v", e.Address, e.Err)
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError is returned when the esl authentication fails.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("auth: %v", e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// APIError is returned when an api command response is an error, i.e.
+// starts with -ERR or -USAGE.
+type APIError struct {
+	Command string // api command and its arguments
+	Body    string // api response body
+	Code    string // APIErrFailed or APIErrUsage
+	Reason  string // response body without the error code
+}
+
+// Api error codes.
+const (
+	APIErrFailed = "ERR"   // the command failed
+	APIErrUsage  = "USAGE" // the command arguments are invalid
+)
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api %s: %s", e.Command, e.Body)
+}
+
+// apiError returns an *APIError for the command cmd if its response body is an
+// error, or nil otherwise.
+func apiError(cmd, body string) error {
+	resp := strings.TrimSpace(body)
+	for _, code := range []string{APIErrFailed, APIErrUsage} {
+		if strings.HasPrefix(resp, "-"+code) {
+			reason := strings.TrimPrefix(resp, "-"+code)
+			reason = strings.TrimSpace(strings.TrimPrefix(reason, ":"))
+			return &APIError{Command: cmd, Body: resp, Code: code, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// cmdString formats cmd and its args as sent to freeswitch.
+func cmdString(cmd string, args []string) string {
+	return strings.TrimSpace(cmd + " " + strings.Join(args, " "))
+}
+
+// isNoSuchChannel tells if the api error body reports a missing channel.
+func isNoSuchChannel(body string) bool {
+	body = strings.ToLower(body)
+	return strings.Contains(body, "no such channel") || strings.Contains(body, "invalid uuid")
+}
+
+// channelError returns ErrNoSuchChannel (wrapped with msg) if err is an api error
+// reporting a missing channel, or err wrapped with msg otherwise.
+func channelError(msg string, err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && isNoSuchChannel(apiErr.Body) {
+		return fmt.Errorf("%s: %w", msg, ErrNoSuchChannel)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// transferError is channelError also returning ErrInvalidExtension (wrapped
+// with msg) if err is an api error rejecting the destination extension.
+func transferError(msg string, err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && strings.Contains(strings.ToLower(apiErr.Body), "extension") {
+		return fmt.Errorf("%s: %w", msg, ErrInvalidExtension)
+	}
+	return channelError(msg, err)
+}
@@ -7,43 +7,109 @@ package esl
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
-	"log"
 	"net/textproto"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type MIMEMap struct {
-	Map       textproto.MIMEHeader
+	Map textproto.MIMEHeader
+	// IsEscaped tells if the Map values are url encoded (and unescaped by
+	// Get). It is set for the bodies of plain and xml events but not json ones,
+	// and for the headers of escaped command replies and of the outbound
+	// channel data.
 	IsEscaped bool
 }
 
 type Event struct {
-	UId     string
-	Name    EventName
-	App     string
+	UId  string
+	Name EventName
+	App  string
+	// AppData is the unescaped Application-Data header, kept as is (leading
+	// and trailing spaces included). CHANNEL_EXECUTE and CHANNEL_EXECUTE_COMPLETE
+	// events both carry the arguments of the executed application; the
+	// application result is in the Application-Response header of the latter.
 	AppData string
 	Stamp   int
-	Type    EventType
-	Header  MIMEMap
-	Body    MIMEMap
-	RawBody []byte
+	// Subclass is the Event-Subclass of CUSTOM events.
+	Subclass string
+	// LogLevel (0 to 7, 7 being debug) and LogText are the level and the
+	// message of EventLog events, whose UId is the channel uuid, if any.
+	LogLevel int
+	LogText  string
+	// DisconnectReason tells, for EventDisconnect events, why freeswitch
+	// disconnects.
+	DisconnectReason DisconnectReason
+	Type             EventType
+	Header           MIMEMap
+	Body             MIMEMap
+	RawBody          []byte
+	// textBody holds the event body when it is not embedded in RawBody (json
+	// and xml events), as told by hasTextBody.
+	textBody    string
+	hasTextBody bool
+	// bodyLen is the length of a detached (unread) body, see readEvent.
+	bodyLen int64
+	// stream is the reader of a detached api response body, see ApiStream.
+	stream *bodyStream
+	// pool and buf are the pool and the buffer RawBody comes from, refs the
+	// number of releases left before buf is reused, see ReleaseEvent.
+	pool *sync.Pool
+	buf  *[]byte
+	refs int32
 }
 
 type EventType int
 
 const (
-	EventError EventType = iota
-	EventState
-	EventConnect
-	EventAuth
-	EventCommandReply
-	EventApiResponse
-	EventDisconnect
-	EventGeneric
+	EventError        EventType = iota
+	EventState                  // reserved, not used
+	EventConnect                // outbound connect reply (channel data), see Connection.ChannelData
+	EventAuth                   // auth/request
+	EventCommandReply           // command/reply
+	EventApiResponse            // api/response
+	EventDisconnect             // text/disconnect-notice or text/rude-rejection
+	EventGeneric                // text/event-plain, text/event-json or text/event-xml
+	EventLog                    // log/data, see Connection.EnableLog
+)
+
+var eventTypeNames = [...]string{
+	EventError:        "EventError",
+	EventState:        "EventState",
+	EventConnect:      "EventConnect",
+	EventAuth:         "EventAuth",
+	EventCommandReply: "EventCommandReply",
+	EventApiResponse:  "EventApiResponse",
+	EventDisconnect:   "EventDisconnect",
+	EventGeneric:      "EventGeneric",
+	EventLog:          "EventLog",
+}
+
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventTypeNames) {
+		return fmt.Sprintf("EventType(%d)", t)
+	}
+	return eventTypeNames[t]
+}
+
+// DisconnectReason is the reason of an EventDisconnect.
+type DisconnectReason int
+
+const (
+	// DisconnectNotice is a graceful disconnection (text/disconnect-notice),
+	// e.g. after exit or a channel hangup in outbound mode.
+	DisconnectNotice DisconnectReason = iota + 1
+	// RudeRejection is a rejection by the esl ACL (text/rude-rejection):
+	// retrying is useless.
+	RudeRejection
 )
 
 //go:generate enumer -type=EventName
@@ -140,17 +206,105 @@ const (
 	CALL_DETAIL
 	DEVICE_STATE
 	ALL
+	// EventNameUnknown is the Name of the events whose Event-Name is missing
+	// or unknown, as opposed to actual CUSTOM events. It is not a freeswitch
+	// event name and can't be subscribed.
+	EventNameUnknown
 )
 
+// IsChannel reports whether n is a CHANNEL_* event.
+func (n EventName) IsChannel() bool {
+	return strings.HasPrefix(n.String(), "CHANNEL_")
+}
+
+// HasUUID reports whether the n events are fired by a channel and carry its
+// Unique-ID header: the CHANNEL_* events and the other session events.
+func (n EventName) HasUUID() bool {
+	if n.IsChannel() {
+		return true
+	}
+	switch n {
+	case TALK, NOTALK, DTMF, CODEC, DETECTED_SPEECH, DETECTED_TONE,
+		PRIVATE_COMMAND, SESSION_HEARTBEAT, SEND_INFO, RECV_INFO,
+		RECV_RTCP_MESSAGE, CALL_SECURE, RECORD_START, RECORD_STOP,
+		PLAYBACK_START, PLAYBACK_STOP, CALL_UPDATE, MEDIA_BUG_START,
+		MEDIA_BUG_STOP, CALL_DETAIL:
+		return true
+	}
+	return false
+}
+
+// DefaultMaxBodySize is the maximum event body size accepted by NewEventFromReader.
+const DefaultMaxBodySize = 10 << 20
+
+// DefaultMaxHeaderBytes is the maximum event header section size accepted by
+// NewEventFromReader.
+const DefaultMaxHeaderBytes = 1 << 20
+
+// NewEventFromReader reads the next event from r. Each event is parsed
+// according to its own Content-Type, so that a single stream can mix plain,
+// json and xml events with the command replies and api responses. A body
+// larger than DefaultMaxBodySize is skipped: the header only event is returned
+// with ErrBodyTooLarge.
 func NewEventFromReader(r *bufio.Reader) (*Event, error) {
+	return readEvent(r, DefaultMaxHeaderBytes, DefaultMaxBodySize, nil, nil)
+}
+
+// ReleaseEvent tells that the caller is done with e, which must not be used
+// anymore (including its RawBody). Each handler e is passed to may release it
+// once: its body buffer goes back to the Connection.BodyPool it was taken from
+// when all of them did. The events kept by the library (BgApiJob results,
+// WaitForEvent...) are never reused. It does nothing if e body doesn't come
+// from a pool.
+func ReleaseEvent(e *Event) {
+	if e == nil || e.pool == nil || atomic.AddInt32(&e.refs, -1) != 0 {
+		return
+	}
+	e.pool.Put(e.buf)
+	e.RawBody = nil
+	e.pool, e.buf = nil, nil
+}
+
+// share sets the number of handlers e is passed to, which must all release it
+// before its body buffer is reused. If e is kept by the library, it is taken out
+// of the pool instead: its buffer is left to the garbage collector.
+func (e *Event) share(handlers int, kept bool) {
+	if e.pool == nil {
+		return
+	}
+	if kept {
+		e.pool, e.buf = nil, nil
+		return
+	}
+	atomic.StoreInt32(&e.refs, int32(handlers))
+	if handlers == 0 {
+		// not passed to anyone
+		e.pool.Put(e.buf)
+		e.RawBody = nil
+		e.pool, e.buf = nil, nil
+	}
+}
+
+// readEvent reads an event from r. A header section larger than maxHeader
+// bytes makes it fail with ErrHeaderTooLarge. Bodies larger than maxBody bytes
+// are skipped and ErrBodyTooLarge is returned along with the header only event,
+// whose Type is set for command replies and api responses. If detach is not nil and returns
+// true for the event, its body is left unread in r, its length stored in
+// e.bodyLen. If pool is not nil, the body buffer is taken from it (see
+// ReleaseEvent).
+func readEvent(r *bufio.Reader, maxHeader, maxBody int, detach func(e *Event) bool, pool *sync.Pool) (*Event, error) {
 	var err error
 	e := &Event{}
 
-	e.Header.Map, err = textproto.NewReader(r).ReadMIMEHeader()
-	if err == io.EOF {
-		// connection closed
+	hdr, err := readHeader(r, maxHeader)
+	if err == io.EOF || err == ErrHeaderTooLarge {
+		// connection closed or stream out of sync
 		return nil, err
 	}
+	if err != nil {
+		return nil, fmt.Errorf("read headers: %v", err)
+	}
+	e.Header.Map, err = textproto.NewReader(bufio.NewReader(bytes.NewReader(hdr))).ReadMIMEHeader()
 	if err != nil {
 		return nil, fmt.Errorf("parse headers: %v", err)
 	}
@@ -160,8 +314,41 @@ func NewEventFromReader(r *bufio.Reader) (*Event, error) {
 		if err != nil {
 			return nil, fmt.Errorf("convert content-length %s: %v", slen, err)
 		}
-		e.RawBody = make([]byte, len)
+		if len < 0 {
+			return nil, fmt.Errorf("invalid content-length %d", len)
+		}
+		if detach != nil && detach(e) {
+			e.Type = EventApiResponse
+			e.bodyLen = int64(len)
+			return e, nil
+		}
+		if len > maxBody {
+			// skip the body to stay in sync with the stream
+			if _, err := io.CopyN(io.Discard, r, int64(len)); err != nil {
+				if err == io.EOF {
+					return nil, ErrTruncatedBody
+				}
+				return nil, fmt.Errorf("skip body: %v", err)
+			}
+			switch e.Get("Content-Type") {
+			case "command/reply":
+				e.Type = EventCommandReply
+			case "api/response":
+				e.Type = EventApiResponse
+			}
+			return e, ErrBodyTooLarge
+		}
+		if pool != nil {
+			e.pool, e.buf = pool, bodyBuffer(pool, len)
+			e.RawBody = *e.buf
+			atomic.StoreInt32(&e.refs, 1)
+		} else {
+			e.RawBody = make([]byte, len)
+		}
 		_, err = io.ReadFull(r, e.RawBody)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrTruncatedBody
+		}
 		if err != nil {
 			return nil, fmt.Errorf("read body: %v", err)
 		}
@@ -172,36 +359,102 @@ func NewEventFromReader(r *bufio.Reader) (*Event, error) {
 		e.Type = EventAuth
 	case "command/reply":
 		e.Type = EventCommandReply
-		reply := e.Get("Reply-Text")
-		if !strings.Contains(reply, "+OK") && !strings.Contains(reply, "%2BOK") {
-			return nil, fmt.Errorf("command error: %s", strings.TrimSpace(reply))
-		}
-		if strings.Contains(reply, "%") {
+		// the reply (+OK, -ERR...) is interpreted by the command methods
+		if strings.Contains(e.Get("Reply-Text"), "%") {
 			e.Header.IsEscaped = true
 		}
 	case "text/event-plain":
 		e.Type = EventGeneric
 		err = e.parseTextBody()
-	case "text/event-json", "text/event-xml":
-		err = fmt.Errorf("unsupported format %s", e.Get("Content-Type"))
-	case "text/disconnect-notice", "text/rude-rejection":
+	case "text/event-json":
+		e.Type = EventGeneric
+		err = e.parseJSONBody()
+	case "text/event-xml":
+		e.Type = EventGeneric
+		err = e.parseXMLBody()
+	case "text/disconnect-notice":
+		e.Type = EventDisconnect
+		e.DisconnectReason = DisconnectNotice
+	case "text/rude-rejection":
 		e.Type = EventDisconnect
+		e.DisconnectReason = RudeRejection
 	case "api/response":
 		e.Type = EventApiResponse
+	case "log/data":
+		e.Type = EventLog
+		e.Name = LOG
+		e.UId = e.Get("User-Data")
+		e.LogLevel, _ = strconv.Atoi(e.Get("Log-Level"))
+		e.LogText = string(e.RawBody)
 	}
 	return e, err
 }
 
+// bodyBuffer returns a size bytes buffer taken from pool, or a new one if the
+// pool has none large enough.
+func bodyBuffer(pool *sync.Pool, size int) *[]byte {
+	buf, ok := pool.Get().(*[]byte)
+	if !ok || cap(*buf) < size {
+		b := make([]byte, size)
+		return &b
+	}
+	*buf = (*buf)[:size]
+	return buf
+}
+
+// readHeader reads the header section of an event from r, up to and including
+// the empty line ending it, failing with ErrHeaderTooLarge if it is larger than
+// max bytes. It returns io.EOF if r is at EOF.
+func readHeader(r *bufio.Reader, max int) ([]byte, error) {
+	var hdr []byte
+	start := 0 // start of the current line in hdr
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(hdr)+len(chunk) > max {
+			return nil, ErrHeaderTooLarge
+		}
+		hdr = append(hdr, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(hdr) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line := hdr[start:]; len(line) == 1 || (len(line) == 2 && line[0] == '\r') {
+			if start == 0 {
+				// skip the blank lines before the headers
+				hdr = hdr[:0]
+				continue
+			}
+			return hdr, nil
+		}
+		start = len(hdr)
+	}
+}
+
+// GetTextBody returns the body of the event (e.g. the result of a BACKGROUND_JOB),
+// i.e. the Content-Length bytes of RawBody following the event headers of plain
+// events, the _body value of json events or the <body> element of xml events.
 func (e *Event) GetTextBody() string {
+	if e.hasTextBody {
+		return e.textBody
+	}
 	slen := e.Body.Get("Content-Length")
 	if slen != "" {
 		bblen, err := strconv.Atoi(slen)
 		if err != nil {
-			log.Println("ERR: convert body len %s: %v", slen, err)
+			DefaultLogger.Printf("ERR: convert body len %s: %v", slen, err)
 			return ""
 		}
 		blen := len(e.RawBody)
-		return string(e.RawBody[blen-bblen : blen-1])
+		if bblen < 0 || bblen > blen {
+			DefaultLogger.Printf("ERR: body len %d out of event len %d", bblen, blen)
+			return ""
+		}
+		return string(e.RawBody[blen-bblen:])
 	}
 	return ""
 }
@@ -216,15 +469,86 @@ func (e Event) Get(header string) string {
 	return val
 }
 
+// Escaped tells if the event header values are url encoded, see
+// MIMEMap.IsEscaped.
+func (e *Event) Escaped() bool {
+	return e.Header.IsEscaped
+}
+
+// JobUUID returns the background job uuid of a bgapi command reply or of a
+// BACKGROUND_JOB event. Header keys are canonicalized, so the Job-UUID header
+// is found whatever its case.
+func (e *Event) JobUUID() string {
+	return e.Get("Job-UUID")
+}
+
+// Time returns the event date, from its Event-Date-Timestamp header (in
+// microseconds since the Unix epoch). It returns the zero time if the header is
+// missing or invalid.
+func (e *Event) Time() time.Time {
+	us, err := strconv.ParseInt(e.Get("Event-Date-Timestamp"), 10, 64)
+	if err != nil || us <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(us)
+}
+
 func (e Event) String() string {
-	body, _ := url.QueryUnescape(string(e.RawBody))
+	body, _ := url.PathUnescape(string(e.RawBody))
 	return fmt.Sprintf("%s\n.\n%s====================\n", e.Header, body)
 }
 
+// MarshalJSON encodes the event as a json object with its parsed fields, its
+// unescaped headers and body headers and its text body. The event type and
+// name are encoded as strings.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		UId      string            `json:"uid,omitempty"`
+		Name     string            `json:"name"`
+		Subclass string            `json:"subclass,omitempty"`
+		App      string            `json:"app,omitempty"`
+		AppData  string            `json:"app_data,omitempty"`
+		Stamp    int               `json:"stamp,omitempty"`
+		Type     string            `json:"type"`
+		Header   map[string]string `json:"header"`
+		Body     map[string]string `json:"body,omitempty"`
+		TextBody string            `json:"text_body,omitempty"`
+	}{
+		UId:      e.UId,
+		Name:     e.Name.String(),
+		Subclass: e.Subclass,
+		App:      e.App,
+		AppData:  e.AppData,
+		Stamp:    e.Stamp,
+		Type:     e.Type.String(),
+		Header:   e.Header.unescaped(),
+		Body:     e.Body.unescaped(),
+		TextBody: e.GetTextBody(),
+	})
+}
+
+// unescaped returns the map values unescaped, multiple values being joined by commas.
+func (m MIMEMap) unescaped() map[string]string {
+	if m.Map == nil {
+		return nil
+	}
+	vals := make(map[string]string, len(m.Map))
+	for k, v := range m.Map {
+		val := strings.Join(v, ",")
+		if m.IsEscaped {
+			val, _ = url.PathUnescape(val)
+		}
+		vals[k] = val
+	}
+	return vals
+}
+
+// Get returns the value of header key, unescaped if the map is escaped.
+// Freeswitch escapes '+' as %2B, so a literal '+' is kept as is.
 func (m MIMEMap) Get(key string) string {
 	val := m.Map.Get(key)
 	if m.IsEscaped {
-		val, _ = url.QueryUnescape(val)
+		val, _ = url.PathUnescape(val)
 	}
 	return val
 }
@@ -234,7 +558,7 @@ func (m MIMEMap) String() string {
 	for k, v := range m.Map {
 		val := strings.Join(v, ",")
 		if m.IsEscaped {
-			val, _ = url.QueryUnescape(val)
+			val, _ = url.PathUnescape(val)
 		}
 		s += fmt.Sprintf("%s: %s\n", k, val)
 	}
@@ -248,10 +572,82 @@ func (e *Event) parseTextBody() error {
 		return fmt.Errorf("parse text body: %v", err)
 	}
 	e.Body.IsEscaped = true
+	e.parseFields()
+	return nil
+}
+
+// parseJSONBody parses a text/event-json body. Each JSON key becomes a single-valued
+// body header, except _body, the event body returned by GetTextBody. JSON
+// values are not url escaped.
+func (e *Event) parseJSONBody() error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(e.RawBody, &fields); err != nil {
+		return fmt.Errorf("parse json body: %v", err)
+	}
+	if body, ok := fields["_body"]; ok {
+		e.textBody = fmt.Sprint(body)
+		delete(fields, "_body")
+	}
+	e.hasTextBody = true
+	e.Body.Map = make(textproto.MIMEHeader, len(fields))
+	for k, v := range fields {
+		if s, ok := v.(string); ok {
+			e.Body.Map.Set(k, s)
+		} else {
+			e.Body.Map.Set(k, fmt.Sprint(v))
+		}
+	}
+	e.Body.IsEscaped = false
+	e.parseFields()
+	return nil
+}
+
+// xmlEvent is the structure of a text/event-xml body.
+type xmlEvent struct {
+	Headers struct {
+		Items []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	} `xml:"headers"`
+	Body string `xml:"body"`
+}
+
+// parseXMLBody parses a text/event-xml body. Each element of <headers> becomes
+// a body header and the <body> content, if any, is returned by GetTextBody.
+// Header values are url escaped as in plain events.
+func (e *Event) parseXMLBody() error {
+	var xev xmlEvent
+	if err := xml.Unmarshal(e.RawBody, &xev); err != nil {
+		return fmt.Errorf("parse xml body: %v", err)
+	}
+	e.Body.Map = make(textproto.MIMEHeader, len(xev.Headers.Items))
+	for _, h := range xev.Headers.Items {
+		e.Body.Map.Add(h.XMLName.Local, h.Value)
+	}
+	e.Body.IsEscaped = true
+	e.textBody, e.hasTextBody = xev.Body, true
+	e.parseFields()
+	return nil
+}
+
+// eventName returns the EventName of the Event-Name value s, or
+// EventNameUnknown if s is not a freeswitch event name.
+func eventName(s string) EventName {
+	name, err := EventNameString(s)
+	if err != nil || name > ALL {
+		return EventNameUnknown
+	}
+	return name
+}
+
+// parseFields fills the event fields from the parsed headers. Stamp is left to
+// 0 if Event-Date-Timestamp is missing or invalid (e.g. on some CUSTOM events).
+func (e *Event) parseFields() {
 	e.UId = e.Get("Unique-ID")
-	e.Name, _ = EventNameString(e.Get("Event-Name"))
+	e.Name = eventName(e.Get("Event-Name"))
+	e.Subclass = e.Get("Event-Subclass")
 	e.App = e.Get("Application")
-	e.AppData = strings.TrimSpace(e.Get("Application-Data"))
-	e.Stamp, err = strconv.Atoi(e.Get("Event-Date-Timestamp"))
-	return err
+	e.AppData = e.Get("Application-Data")
+	e.Stamp, _ = strconv.Atoi(e.Get("Event-Date-Timestamp"))
 }
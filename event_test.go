@@ -0,0 +1,263 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// plainEvent returns the text/event-plain event with the given body.
+func plainEvent(body string) string {
+	return fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-plain\n\n%s", len(body), body)
+}
+
+// readEvents reads all the events of raw.
+func readEvents(t *testing.T, raw string) []*Event {
+	t.Helper()
+	r := bufio.NewReader(strings.NewReader(raw))
+	var evs []*Event
+	for {
+		ev, err := NewEventFromReader(r)
+		if err == io.EOF {
+			return evs
+		}
+		if err != nil {
+			t.Fatalf("read event #%d: %v", len(evs)+1, err)
+		}
+		evs = append(evs, ev)
+	}
+}
+
+func TestJobUUID(t *testing.T) {
+	for _, key := range []string{"Job-UUID", "Job-Uuid", "job-uuid"} {
+		reply := "Content-Type: command/reply\nReply-Text: +OK Job-UUID: 1234\n" + key + ": 1234\n\n"
+		job := plainEvent("Event-Name: BACKGROUND_JOB\n" + key + ": 1234\nContent-Length: 9\n\n+OK done\n")
+		for _, ev := range readEvents(t, reply+job) {
+			if got := ev.JobUUID(); got != "1234" {
+				t.Errorf("%s %s: got job uuid %q, want 1234", key, ev.Type, got)
+			}
+		}
+	}
+}
+
+func TestAppData(t *testing.T) {
+	ev := readEvents(t, plainEvent("Event-Name: CHANNEL_EXECUTE\nApplication: playback\nApplication-Data: %20say%20a+b%2Bc%20\n\n"))[0]
+	if want := " say a+b+c "; ev.AppData != want {
+		t.Errorf("got app data %q, want %q", ev.AppData, want)
+	}
+	if ev.App != "playback" {
+		t.Errorf("got app %q, want playback", ev.App)
+	}
+}
+
+func TestGetTextBody(t *testing.T) {
+	body := func(raw string, length string) *Event {
+		return &Event{
+			RawBody: []byte(raw),
+			Body:    MIMEMap{Map: textproto.MIMEHeader{"Content-Length": {length}}},
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		ev   *Event
+		want string
+	}{
+		{"event", readEvents(t, plainEvent("Event-Name: BACKGROUND_JOB\nContent-Length: 9\n\n+OK done\n"))[0], "+OK done\n"},
+		{"equal length", body("+OK\n", "4"), "+OK\n"},
+		{"short body", body("+OK\n", "10"), ""},
+		{"negative length", body("+OK\n", "-1"), ""},
+		{"no length", body("+OK\n", ""), ""},
+	} {
+		if got := tc.ev.GetTextBody(); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMissingTimestamp(t *testing.T) {
+	ev := readEvents(t, plainEvent("Event-Name: CUSTOM\nEvent-Subclass: conference%3A%3Amaintenance\n\n"))[0]
+	if ev.Name != CUSTOM || ev.Subclass != "conference::maintenance" {
+		t.Errorf("got %s %q, want CUSTOM conference::maintenance", ev.Name, ev.Subclass)
+	}
+	if ev.Stamp != 0 || !ev.Time().IsZero() {
+		t.Errorf("got stamp %d (%v), want none", ev.Stamp, ev.Time())
+	}
+}
+
+func TestMixedFormats(t *testing.T) {
+	json := `{"Event-Name":"CHANNEL_ANSWER","Unique-ID":"1234","Answer-State":"answered"}`
+	raw := fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-json\n\n%s", len(json), json) +
+		"Content-Type: command/reply\nReply-Text: +OK event listener enabled json\n\n" +
+		plainEvent("Event-Name: CHANNEL_HANGUP\nUnique-ID: 1234\n\n")
+	xml := `<event><headers><Event-Name>BACKGROUND_JOB</Event-Name><Job-UUID>5678</Job-UUID>` +
+		`<Job-Command>show%20calls</Job-Command></headers><body>+OK 0 total.
+</body></event>`
+	raw += fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-xml\n\n%s", len(xml), xml)
+	evs := readEvents(t, raw)
+	if len(evs) != 4 {
+		t.Fatalf("got %d events, want 4", len(evs))
+	}
+	if ev := evs[0]; ev.Type != EventGeneric || ev.Name != CHANNEL_ANSWER || ev.UId != "1234" || ev.Get("Answer-State") != "answered" {
+		t.Errorf("json event: got %s %s %q [%s]", ev.Type, ev.Name, ev.UId, ev.Body)
+	}
+	if ev := evs[1]; ev.Type != EventCommandReply || ev.Get("Reply-Text") != "+OK event listener enabled json" {
+		t.Errorf("command reply: got %s [%s]", ev.Type, ev.Header)
+	}
+	if ev := evs[2]; ev.Type != EventGeneric || ev.Name != CHANNEL_HANGUP || ev.UId != "1234" {
+		t.Errorf("plain event: got %s %s %q", ev.Type, ev.Name, ev.UId)
+	}
+	if ev := evs[3]; ev.Type != EventGeneric || ev.Name != BACKGROUND_JOB || ev.JobUUID() != "5678" || ev.Get("Job-Command") != "show calls" {
+		t.Errorf("xml event: got %s %s %q [%s]", ev.Type, ev.Name, ev.JobUUID(), ev.Body)
+	}
+	if body := evs[3].GetTextBody(); body != "+OK 0 total.\n" {
+		t.Errorf("xml event: got body %q", body)
+	}
+}
+
+func TestJSONTextBody(t *testing.T) {
+	for _, tc := range []struct {
+		json string
+		want string
+	}{
+		{`{"Event-Name":"BACKGROUND_JOB","Job-UUID":"1234","Content-Length":"9","_body":"+OK done\n"}`, "+OK done\n"},
+		{`{"Event-Name":"BACKGROUND_JOB","Job-UUID":"1234"}`, ""},
+	} {
+		raw := fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-json\n\n%s", len(tc.json), tc.json)
+		ev := readEvents(t, raw)[0]
+		if got := ev.GetTextBody(); got != tc.want {
+			t.Errorf("got body %q, want %q", got, tc.want)
+		}
+		if ev.Get("_body") != "" {
+			t.Error("_body kept in the body headers")
+		}
+		if ev.JobUUID() != "1234" {
+			t.Errorf("got job uuid %q, want 1234", ev.JobUUID())
+		}
+	}
+}
+
+func TestReleaseEventShared(t *testing.T) {
+	con, s := pipeConnection(t, newTestHandler())
+	con.BodyPool = &sync.Pool{}
+	bodies := make(chan string, 4)
+	con.On(CHANNEL_ANSWER, func(con *Connection, ev *Event) {
+		ReleaseEvent(ev)
+	})
+	con.OnAny(func(con *Connection, ev *Event) {
+		// the first handler released ev, it must still be usable here
+		bodies <- string(ev.RawBody)
+		ReleaseEvent(ev)
+	})
+	w := con.addWaiter("abc", CHANNEL_ANSWER, nil)
+	handleEvents(con)
+	answer := "Event-Name: CHANNEL_ANSWER\nUnique-ID: abc\n\n"
+	s.event(answer)
+	waited, err := con.await(context.Background(), w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{answer, strings.Replace(answer, "abc", "xyz", 1)} {
+		if body != answer {
+			s.event(body)
+		}
+		select {
+		case got := <-bodies:
+			if got != body {
+				t.Errorf("handler got body %q, want %q", got, body)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("event not dispatched")
+		}
+	}
+	// the buffer of the waited event must not have been reused
+	if string(waited.RawBody) != answer {
+		t.Errorf("waited event body %q, want %q", waited.RawBody, answer)
+	}
+}
+
+// BenchmarkReadEvent reads plain events released once read, with and without
+// a body buffer pool.
+func BenchmarkReadEvent(b *testing.B) {
+	body := "Event-Name: CHANNEL_EXECUTE\nUnique-ID: abc\n" + strings.Repeat("variable_x: some%20value\n", 40) + "\n"
+	raw := plainEvent(body)
+	for _, bc := range []struct {
+		name string
+		pool *sync.Pool
+	}{
+		{"NoPool", nil},
+		{"Pool", &sync.Pool{}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			src := strings.NewReader(raw)
+			r := bufio.NewReader(src)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				src.Reset(raw)
+				r.Reset(src)
+				ev, err := readEvent(r, DefaultMaxHeaderBytes, DefaultMaxBodySize, nil, bc.pool)
+				if err != nil {
+					b.Fatal(err)
+				}
+				ReleaseEvent(ev)
+			}
+		})
+	}
+}
+
+func TestCDR(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	for _, tc := range []struct {
+		name    string
+		headers string
+		want    *CDR // nil if it must fail
+	}{
+		{"answered", "Event-Name: CHANNEL_HANGUP_COMPLETE\nUnique-ID: 1234\n" +
+			"Caller-Caller-ID-Number: 1000\nCaller-Destination-Number: 2000\n" +
+			"variable_hangup_cause: NORMAL_CLEARING\nvariable_start_uepoch: 1700000000000000\n" +
+			"variable_answer_uepoch: 1700000002500000\nvariable_end_uepoch: 1700000010000000\n" +
+			"variable_duration: 10\nvariable_billsec: 7\n",
+			&CDR{CallUUID: "1234", CallerIDNumber: "1000", DestinationNumber: "2000",
+				StartStamp: start, AnswerStamp: start.Add(2500 * time.Millisecond), EndStamp: start.Add(10 * time.Second),
+				Duration: 10 * time.Second, BillSec: 7 * time.Second, HangupCause: HangupNormalClearing}},
+		{"not answered, epoch stamps", "Event-Name: CHANNEL_HANGUP_COMPLETE\nUnique-ID: 1234\n" +
+			"Hangup-Cause: NO_ANSWER\nvariable_start_epoch: 1700000000\nvariable_answer_epoch: 0\n" +
+			"variable_end_epoch: 1700000030\nvariable_duration: 30\nvariable_billsec: 0\n",
+			&CDR{CallUUID: "1234", StartStamp: start, EndStamp: start.Add(30 * time.Second),
+				Duration: 30 * time.Second, HangupCause: HangupNoAnswer}},
+		{"not a hangup complete", "Event-Name: CHANNEL_HANGUP\nUnique-ID: 1234\n", nil},
+		{"no unique id", "Event-Name: CHANNEL_HANGUP_COMPLETE\n", nil},
+		{"bad stamp", "Event-Name: CHANNEL_HANGUP_COMPLETE\nUnique-ID: 1234\nvariable_start_uepoch: soon\n", nil},
+		{"bad duration", "Event-Name: CHANNEL_HANGUP_COMPLETE\nUnique-ID: 1234\nvariable_billsec: 1.5\n", nil},
+	} {
+		ev := readEvents(t, plainEvent(tc.headers+"\n"))[0]
+		cdr, err := ev.CDR()
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%s: got %+v, want an error", tc.name, cdr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !cdr.StartStamp.Equal(tc.want.StartStamp) || !cdr.AnswerStamp.Equal(tc.want.AnswerStamp) || !cdr.EndStamp.Equal(tc.want.EndStamp) {
+			t.Errorf("%s: got stamps %v %v %v, want %v %v %v", tc.name, cdr.StartStamp, cdr.AnswerStamp, cdr.EndStamp,
+				tc.want.StartStamp, tc.want.AnswerStamp, tc.want.EndStamp)
+		}
+		cdr.StartStamp, cdr.AnswerStamp, cdr.EndStamp = tc.want.StartStamp, tc.want.AnswerStamp, tc.want.EndStamp
+		if *cdr != *tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, *cdr, *tc.want)
+		}
+	}
+}
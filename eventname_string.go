@@ -6,9 +6,9 @@ import (
 	"fmt"
 )
 
-const _EventName_name = "CUSTOMCLONECHANNEL_CREATECHANNEL_DESTROYCHANNEL_STATECHANNEL_CALLSTATECHANNEL_ANSWERCHANNEL_HANGUPCHANNEL_HANGUP_COMPLETECHANNEL_EXECUTECHANNEL_EXECUTE_COMPLETECHANNEL_HOLDCHANNEL_UNHOLDCHANNEL_BRIDGECHANNEL_UNBRIDGECHANNEL_PROGRESSCHANNEL_PROGRESS_MEDIACHANNEL_OUTGOINGCHANNEL_PARKCHANNEL_UNPARKCHANNEL_APPLICATIONCHANNEL_ORIGINATECHANNEL_UUIDAPILOGINBOUND_CHANOUTBOUND_CHANSTARTUPSHUTDOWNPUBLISHUNPUBLISHTALKNOTALKSESSION_CRASHMODULE_LOADMODULE_UNLOADDTMFMESSAGEPRESENCE_INNOTIFY_INPRESENCE_OUTPRESENCE_PROBEMESSAGE_WAITINGMESSAGE_QUERYROSTERCODECBACKGROUND_JOBDETECTED_SPEECHDETECTED_TONEPRIVATE_COMMANDHEARTBEATTRAPADD_SCHEDULEDEL_SCHEDULEEXE_SCHEDULERE_SCHEDULERELOADXMLNOTIFYPHONE_FEATUREPHONE_FEATURE_SUBSCRIBESEND_MESSAGERECV_MESSAGEREQUEST_PARAMSCHANNEL_DATAGENERALCOMMANDSESSION_HEARTBEATCLIENT_DISCONNECTEDSERVER_DISCONNECTEDSEND_INFORECV_INFORECV_RTCP_MESSAGECALL_SECURENATRECORD_STARTRECORD_STOPPLAYBACK_STARTPLAYBACK_STOPCALL_UPDATEFAILURESOCKET_DATAMEDIA_BUG_STARTMEDIA_BUG_STOPCONFERENCE_DATA_QUERYCONFERENCE_DATACALL_SETUP_REQCALL_SETUP_RESULTCALL_DETAILDEVICE_STATEALL"
+const _EventName_name = "CUSTOMCLONECHANNEL_CREATECHANNEL_DESTROYCHANNEL_STATECHANNEL_CALLSTATECHANNEL_ANSWERCHANNEL_HANGUPCHANNEL_HANGUP_COMPLETECHANNEL_EXECUTECHANNEL_EXECUTE_COMPLETECHANNEL_HOLDCHANNEL_UNHOLDCHANNEL_BRIDGECHANNEL_UNBRIDGECHANNEL_PROGRESSCHANNEL_PROGRESS_MEDIACHANNEL_OUTGOINGCHANNEL_PARKCHANNEL_UNPARKCHANNEL_APPLICATIONCHANNEL_ORIGINATECHANNEL_UUIDAPILOGINBOUND_CHANOUTBOUND_CHANSTARTUPSHUTDOWNPUBLISHUNPUBLISHTALKNOTALKSESSION_CRASHMODULE_LOADMODULE_UNLOADDTMFMESSAGEPRESENCE_INNOTIFY_INPRESENCE_OUTPRESENCE_PROBEMESSAGE_WAITINGMESSAGE_QUERYROSTERCODECBACKGROUND_JOBDETECTED_SPEECHDETECTED_TONEPRIVATE_COMMANDHEARTBEATTRAPADD_SCHEDULEDEL_SCHEDULEEXE_SCHEDULERE_SCHEDULERELOADXMLNOTIFYPHONE_FEATUREPHONE_FEATURE_SUBSCRIBESEND_MESSAGERECV_MESSAGEREQUEST_PARAMSCHANNEL_DATAGENERALCOMMANDSESSION_HEARTBEATCLIENT_DISCONNECTEDSERVER_DISCONNECTEDSEND_INFORECV_INFORECV_RTCP_MESSAGECALL_SECURENATRECORD_STARTRECORD_STOPPLAYBACK_STARTPLAYBACK_STOPCALL_UPDATEFAILURESOCKET_DATAMEDIA_BUG_STARTMEDIA_BUG_STOPCONFERENCE_DATA_QUERYCONFERENCE_DATACALL_SETUP_REQCALL_SETUP_RESULTCALL_DETAILDEVICE_STATEALLEventNameUnknown"
 
-var _EventName_index = [...]uint16{0, 6, 11, 25, 40, 53, 70, 84, 98, 121, 136, 160, 172, 186, 200, 216, 232, 254, 270, 282, 296, 315, 332, 344, 347, 350, 362, 375, 382, 390, 397, 406, 410, 416, 429, 440, 453, 457, 464, 475, 484, 496, 510, 525, 538, 544, 549, 563, 578, 591, 606, 615, 619, 631, 643, 655, 666, 675, 681, 694, 717, 729, 741, 755, 767, 774, 781, 798, 817, 836, 845, 854, 871, 882, 885, 897, 908, 922, 935, 946, 953, 964, 979, 993, 1014, 1029, 1043, 1060, 1071, 1083, 1086}
+var _EventName_index = [...]uint16{0, 6, 11, 25, 40, 53, 70, 84, 98, 121, 136, 160, 172, 186, 200, 216, 232, 254, 270, 282, 296, 315, 332, 344, 347, 350, 362, 375, 382, 390, 397, 406, 410, 416, 429, 440, 453, 457, 464, 475, 484, 496, 510, 525, 538, 544, 549, 563, 578, 591, 606, 615, 619, 631, 643, 655, 666, 675, 681, 694, 717, 729, 741, 755, 767, 774, 781, 798, 817, 836, 845, 854, 871, 882, 885, 897, 908, 922, 935, 946, 953, 964, 979, 993, 1014, 1029, 1043, 1060, 1071, 1083, 1086, 1102}
 
 func (i EventName) String() string {
 	if i < 0 || i >= EventName(len(_EventName_index)-1) {
@@ -108,6 +108,7 @@ var _EventNameNameToValue_map = map[string]EventName{
 	_EventName_name[1060:1071]: 87,
 	_EventName_name[1071:1083]: 88,
 	_EventName_name[1083:1086]: 89,
+	_EventName_name[1086:1102]: 90,
 }
 
 func EventNameString(s string) (EventName, error) {
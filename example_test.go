@@ -0,0 +1,42 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl_test
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/vma/esl"
+)
+
+type statusHandler struct{}
+
+// OnConnect is called by HandleEvents once the read loop runs: commands can
+// be sent from there.
+func (statusHandler) OnConnect(con *esl.Connection) {
+	resp, err := con.Api("status")
+	if err != nil {
+		log.Print(err)
+	} else {
+		fmt.Print(resp)
+	}
+	con.Close()
+}
+
+func (statusHandler) OnEvent(con *esl.Connection, ev *esl.Event)      {}
+func (statusHandler) OnDisconnect(con *esl.Connection, ev *esl.Event) {}
+func (statusHandler) OnClose(con *esl.Connection)                     {}
+
+// This example connects to freeswitch and prints its status.
+func ExampleNewConnection() {
+	con, err := esl.NewConnection("127.0.0.1", statusHandler{}, esl.WithPassword("ClueCon"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// HandleEvents calls OnConnect and returns when the connection is closed.
+	if err := con.HandleEvents(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import "strings"
+
+// HangupCause is a freeswitch hangup cause name, e.g. NORMAL_CLEARING.
+type HangupCause string
+
+// The freeswitch hangup causes.
+const (
+	HangupNone                        HangupCause = "NONE"    // no cause (e.g. no Hangup-Cause header)
+	HangupUnknown                     HangupCause = "UNKNOWN" // not a known cause
+	HangupUnspecified                 HangupCause = "UNSPECIFIED"
+	HangupUnallocatedNumber           HangupCause = "UNALLOCATED_NUMBER"
+	HangupNoRouteTransitNet           HangupCause = "NO_ROUTE_TRANSIT_NET"
+	HangupNoRouteDestination          HangupCause = "NO_ROUTE_DESTINATION"
+	HangupChannelUnacceptable         HangupCause = "CHANNEL_UNACCEPTABLE"
+	HangupCallAwardedDelivered        HangupCause = "CALL_AWARDED_DELIVERED"
+	HangupNormalClearing              HangupCause = "NORMAL_CLEARING"
+	HangupUserBusy                    HangupCause = "USER_BUSY"
+	HangupNoUserResponse              HangupCause = "NO_USER_RESPONSE"
+	HangupNoAnswer                    HangupCause = "NO_ANSWER"
+	HangupSubscriberAbsent            HangupCause = "SUBSCRIBER_ABSENT"
+	HangupCallRejected                HangupCause = "CALL_REJECTED"
+	HangupNumberChanged               HangupCause = "NUMBER_CHANGED"
+	HangupRedirectionToNewDestination HangupCause = "REDIRECTION_TO_NEW_DESTINATION"
+	HangupExchangeRoutingError        HangupCause = "EXCHANGE_ROUTING_ERROR"
+	HangupDestinationOutOfOrder       HangupCause = "DESTINATION_OUT_OF_ORDER"
+	HangupInvalidNumberFormat         HangupCause = "INVALID_NUMBER_FORMAT"
+	HangupFacilityRejected            HangupCause = "FACILITY_REJECTED"
+	HangupResponseToStatusEnquiry     HangupCause = "RESPONSE_TO_STATUS_ENQUIRY"
+	HangupNormalUnspecified           HangupCause = "NORMAL_UNSPECIFIED"
+	HangupNormalCircuitCongestion     HangupCause = "NORMAL_CIRCUIT_CONGESTION"
+	HangupNetworkOutOfOrder           HangupCause = "NETWORK_OUT_OF_ORDER"
+	HangupNormalTemporaryFailure      HangupCause = "NORMAL_TEMPORARY_FAILURE"
+	HangupSwitchCongestion            HangupCause = "SWITCH_CONGESTION"
+	HangupAccessInfoDiscarded         HangupCause = "ACCESS_INFO_DISCARDED"
+	HangupRequestedChanUnavail        HangupCause = "REQUESTED_CHAN_UNAVAIL"
+	HangupPreEmpted                   HangupCause = "PRE_EMPTED"
+	HangupFacilityNotSubscribed       HangupCause = "FACILITY_NOT_SUBSCRIBED"
+	HangupOutgoingCallBarred          HangupCause = "OUTGOING_CALL_BARRED"
+	HangupIncomingCallBarred          HangupCause = "INCOMING_CALL_BARRED"
+	HangupBearerCapabilityNotAuth     HangupCause = "BEARERCAPABILITY_NOTAUTH"
+	HangupBearerCapabilityNotAvail    HangupCause = "BEARERCAPABILITY_NOTAVAIL"
+	HangupServiceUnavailable          HangupCause = "SERVICE_UNAVAILABLE"
+	HangupBearerCapabilityNotImpl     HangupCause = "BEARERCAPABILITY_NOTIMPL"
+	HangupChanNotImplemented          HangupCause = "CHAN_NOT_IMPLEMENTED"
+	HangupFacilityNotImplemented      HangupCause = "FACILITY_NOT_IMPLEMENTED"
+	HangupServiceNotImplemented       HangupCause = "SERVICE_NOT_IMPLEMENTED"
+	HangupInvalidCallReference        HangupCause = "INVALID_CALL_REFERENCE"
+	HangupIncompatibleDestination     HangupCause = "INCOMPATIBLE_DESTINATION"
+	HangupInvalidMsgUnspecified       HangupCause = "INVALID_MSG_UNSPECIFIED"
+	HangupMandatoryIEMissing          HangupCause = "MANDATORY_IE_MISSING"
+	HangupMessageTypeNonExist         HangupCause = "MESSAGE_TYPE_NONEXIST"
+	HangupWrongMessage                HangupCause = "WRONG_MESSAGE"
+	HangupIENonExist                  HangupCause = "IE_NONEXIST"
+	HangupInvalidIEContents           HangupCause = "INVALID_IE_CONTENTS"
+	HangupWrongCallState              HangupCause = "WRONG_CALL_STATE"
+	HangupRecoveryOnTimerExpire       HangupCause = "RECOVERY_ON_TIMER_EXPIRE"
+	HangupMandatoryIELengthError      HangupCause = "MANDATORY_IE_LENGTH_ERROR"
+	HangupProtocolError               HangupCause = "PROTOCOL_ERROR"
+	HangupInterworking                HangupCause = "INTERWORKING"
+	HangupOriginatorCancel            HangupCause = "ORIGINATOR_CANCEL"
+	HangupCrash                       HangupCause = "CRASH"
+	HangupSystemShutdown              HangupCause = "SYSTEM_SHUTDOWN"
+	HangupLoseRace                    HangupCause = "LOSE_RACE"
+	HangupManagerRequest              HangupCause = "MANAGER_REQUEST"
+	HangupBlindTransfer               HangupCause = "BLIND_TRANSFER"
+	HangupAttendedTransfer            HangupCause = "ATTENDED_TRANSFER"
+	HangupAllottedTimeout             HangupCause = "ALLOTTED_TIMEOUT"
+	HangupUserChallenge               HangupCause = "USER_CHALLENGE"
+	HangupMediaTimeout                HangupCause = "MEDIA_TIMEOUT"
+	HangupPickedOff                   HangupCause = "PICKED_OFF"
+	HangupUserNotRegistered           HangupCause = "USER_NOT_REGISTERED"
+	HangupProgressTimeout             HangupCause = "PROGRESS_TIMEOUT"
+	HangupGatewayDown                 HangupCause = "GATEWAY_DOWN"
+)
+
+// hangupCauses is the set of the known causes.
+var hangupCauses = map[HangupCause]bool{
+	HangupNone:                        true,
+	HangupUnspecified:                 true,
+	HangupUnallocatedNumber:           true,
+	HangupNoRouteTransitNet:           true,
+	HangupNoRouteDestination:          true,
+	HangupChannelUnacceptable:         true,
+	HangupCallAwardedDelivered:        true,
+	HangupNormalClearing:              true,
+	HangupUserBusy:                    true,
+	HangupNoUserResponse:              true,
+	HangupNoAnswer:                    true,
+	HangupSubscriberAbsent:            true,
+	HangupCallRejected:                true,
+	HangupNumberChanged:               true,
+	HangupRedirectionToNewDestination: true,
+	HangupExchangeRoutingError:        true,
+	HangupDestinationOutOfOrder:       true,
+	HangupInvalidNumberFormat:         true,
+	HangupFacilityRejected:            true,
+	HangupResponseToStatusEnquiry:     true,
+	HangupNormalUnspecified:           true,
+	HangupNormalCircuitCongestion:     true,
+	HangupNetworkOutOfOrder:           true,
+	HangupNormalTemporaryFailure:      true,
+	HangupSwitchCongestion:            true,
+	HangupAccessInfoDiscarded:         true,
+	HangupRequestedChanUnavail:        true,
+	HangupPreEmpted:                   true,
+	HangupFacilityNotSubscribed:       true,
+	HangupOutgoingCallBarred:          true,
+	HangupIncomingCallBarred:          true,
+	HangupBearerCapabilityNotAuth:     true,
+	HangupBearerCapabilityNotAvail:    true,
+	HangupServiceUnavailable:          true,
+	HangupBearerCapabilityNotImpl:     true,
+	HangupChanNotImplemented:          true,
+	HangupFacilityNotImplemented:      true,
+	HangupServiceNotImplemented:       true,
+	HangupInvalidCallReference:        true,
+	HangupIncompatibleDestination:     true,
+	HangupInvalidMsgUnspecified:       true,
+	HangupMandatoryIEMissing:          true,
+	HangupMessageTypeNonExist:         true,
+	HangupWrongMessage:                true,
+	HangupIENonExist:                  true,
+	HangupInvalidIEContents:           true,
+	HangupWrongCallState:              true,
+	HangupRecoveryOnTimerExpire:       true,
+	HangupMandatoryIELengthError:      true,
+	HangupProtocolError:               true,
+	HangupInterworking:                true,
+	HangupOriginatorCancel:            true,
+	HangupCrash:                       true,
+	HangupSystemShutdown:              true,
+	HangupLoseRace:                    true,
+	HangupManagerRequest:              true,
+	HangupBlindTransfer:               true,
+	HangupAttendedTransfer:            true,
+	HangupAllottedTimeout:             true,
+	HangupUserChallenge:               true,
+	HangupMediaTimeout:                true,
+	HangupPickedOff:                   true,
+	HangupUserNotRegistered:           true,
+	HangupProgressTimeout:             true,
+	HangupGatewayDown:                 true,
+}
+
+// ParseHangupCause returns the hangup cause named s (case insensitive):
+// HangupNone if s is empty, HangupUnknown if it is not a known cause.
+func ParseHangupCause(s string) HangupCause {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return HangupNone
+	}
+	if c := HangupCause(s); hangupCauses[c] {
+		return c
+	}
+	return HangupUnknown
+}
+
+func (c HangupCause) String() string {
+	return string(c)
+}
+
+// HangupCause returns the parsed Hangup-Cause header of the event (e.g. of
+// CHANNEL_HANGUP and CHANNEL_HANGUP_COMPLETE events).
+func (e *Event) HangupCause() HangupCause {
+	return ParseHangupCause(e.Get("Hangup-Cause"))
+}
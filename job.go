@@ -0,0 +1,169 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Job is a background api job, see BgApiJob.
+type Job struct {
+	UUID string
+
+	con      *Connection
+	ctx      context.Context
+	cmd      string        // command and args, for the errors
+	ev       *Event        // BACKGROUND_JOB event, set before finished is closed
+	finished chan struct{} // closed on completion
+	events   chan *Event
+}
+
+// BgApiJob runs cmd with args as a background job and returns its handle. The
+// job is abandoned (see Job.Result), and unregistered, once ctx is done or the
+// connection is lost, even if Result is never called. The connection must be
+// subscribed to BACKGROUND_JOB for the job to complete.
+func (con *Connection) BgApiJob(ctx context.Context, cmd string, args ...string) (*Job, error) {
+	job := &Job{
+		UUID:     newUUID(),
+		con:      con,
+		ctx:      ctx,
+		cmd:      cmdString(cmd, args),
+		finished: make(chan struct{}),
+		events:   make(chan *Event, 16),
+	}
+	con.jobsMu.Lock()
+	if con.jobs == nil {
+		con.jobs = make(map[string]*Job)
+	}
+	con.jobs[job.UUID] = job
+	con.jobsMu.Unlock()
+
+	buf := bytes.NewBufferString("bgapi " + cmd)
+	for _, arg := range args {
+		buf.WriteString(" ")
+		buf.WriteString(arg)
+	}
+	buf.WriteString("\nJob-UUID: " + job.UUID + "\n\n")
+	ev, err := con.exchange(ctx, buf.Bytes(), &con.cmdReplies)
+	if err != nil {
+		con.removeJob(job)
+		return nil, fmt.Errorf("bgapi: %w", err)
+	}
+	if reply := ev.Get("Reply-Text"); strings.HasPrefix(reply, "-ERR") {
+		con.removeJob(job)
+		return nil, &CommandError{Command: "bgapi " + job.cmd, Reply: strings.TrimSpace(reply)}
+	}
+	go job.watch(con.lostChan())
+	return job, nil
+}
+
+// watch unregisters the job if its ctx is done or the connection lost before
+// it completes.
+func (job *Job) watch(lost chan struct{}) {
+	select {
+	case <-job.finished:
+	case <-job.ctx.Done():
+		job.con.removeJob(job)
+	case <-lost:
+		job.con.removeJob(job)
+	}
+}
+
+// BgApiResult runs cmd with args as a background job and waits for the matching
+// BACKGROUND_JOB event. It returns the job result body, or ctx.Err() if ctx is
+// done before the job completes. The connection must be subscribed to
+// BACKGROUND_JOB (e.g. with Subscribe or SubscribeAll), otherwise it waits
+// until ctx is done.
+func (con *Connection) BgApiResult(ctx context.Context, cmd string, args ...string) (string, error) {
+	job, err := con.BgApiJob(ctx, cmd, args...)
+	if err != nil {
+		return "", err
+	}
+	ev, err := job.wait()
+	if err != nil {
+		return "", err
+	}
+	return ev.GetTextBody(), nil
+}
+
+// Result waits for the job completion and returns its result body, or an
+// *APIError if it is -ERR or -USAGE. It returns the BgApiJob ctx.Err() if ctx is done
+// first, or ErrConnectionClosed if the connection is lost.
+func (job *Job) Result() (string, error) {
+	ev, err := job.wait()
+	if err != nil {
+		return "", err
+	}
+	body := ev.GetTextBody()
+	if err := apiError(job.cmd, body); err != nil {
+		return "", err
+	}
+	return body, nil
+}
+
+// Events returns a channel receiving the events correlated to the job (with
+// its Job-UUID header), other than its BACKGROUND_JOB event. The channel is
+// closed when the job completes or is abandoned. Events are dropped if it is
+// full.
+func (job *Job) Events() <-chan *Event {
+	return job.events
+}
+
+// wait waits for the BACKGROUND_JOB event of the job.
+func (job *Job) wait() (*Event, error) {
+	select {
+	case <-job.finished:
+		return job.ev, nil
+	case <-job.ctx.Done():
+		job.con.removeJob(job)
+		return nil, job.ctx.Err()
+	case <-job.con.lostChan():
+		job.con.removeJob(job)
+		return nil, ErrConnectionClosed
+	}
+}
+
+// removeJob unregisters job, if still registered, closing its events channel.
+func (con *Connection) removeJob(job *Job) {
+	con.jobsMu.Lock()
+	defer con.jobsMu.Unlock()
+	if con.jobs[job.UUID] == job {
+		delete(con.jobs, job.UUID)
+		close(job.events)
+	}
+}
+
+// dispatchJob passes the event ev to the job of its Job-UUID, if any: a
+// BACKGROUND_JOB event completes the job, the other events are sent to its
+// Events channel. It tells if ev was kept by the job.
+func (con *Connection) dispatchJob(ev *Event) bool {
+	jobId := ev.JobUUID()
+	if jobId == "" {
+		return false
+	}
+	con.jobsMu.Lock()
+	defer con.jobsMu.Unlock()
+	job := con.jobs[jobId]
+	if job == nil {
+		return false
+	}
+	if ev.Name == BACKGROUND_JOB {
+		delete(con.jobs, jobId)
+		job.ev = ev
+		close(job.finished)
+		close(job.events)
+		return true
+	}
+	select {
+	case job.events <- ev:
+		return true
+	default:
+		con.logf("ERR: job %s event dropped: %s\n", jobId, ev.Name)
+		return false
+	}
+}
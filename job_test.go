@@ -0,0 +1,36 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJobUnregisteredOnCancel(t *testing.T) {
+	con, s := pipeConnection(t, newTestHandler())
+	s.serve(func(cmd string) { s.reply("+OK Job-UUID: 1234") })
+	handleEvents(con)
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := con.BgApiJob(ctx, "status"); err != nil {
+		t.Fatal(err)
+	}
+	// the job is dropped without calling Result
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		con.jobsMu.Lock()
+		n := len(con.jobs)
+		con.jobsMu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cancelled job still registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import "log"
+
+// Logger is the interface used for the package internal logging.
+// *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// DefaultLogger is used by connections without Logger and when no connection
+// is available (e.g. event parsing). It defaults to the standard logger.
+var DefaultLogger Logger = log.Default()
+
+// logf logs with con.Logger, or DefaultLogger if not set.
+func (con *Connection) logf(format string, v ...interface{}) {
+	if con.Logger != nil {
+		con.Logger.Printf(format, v...)
+		return
+	}
+	DefaultLogger.Printf(format, v...)
+}
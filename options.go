@@ -0,0 +1,73 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// Option is a NewConnection option, applied before connecting.
+type Option func(con *Connection)
+
+// WithPassword sets the authentication password (ClueCon by default).
+func WithPassword(password string) Option {
+	return func(con *Connection) { con.Password = password }
+}
+
+// WithTimeout sets the dial timeout (3s by default).
+func WithTimeout(timeout time.Duration) Option {
+	return func(con *Connection) { con.Timeout = timeout }
+}
+
+// WithEventTimeout sets the maximum time to wait for the events confirming a
+// command (DefaultEventTimeout by default), see Connection.EventTimeout.
+func WithEventTimeout(timeout time.Duration) Option {
+	return func(con *Connection) { con.EventTimeout = timeout }
+}
+
+// WithMaxRetries sets the maximum number of connection attempts (3 by default,
+// at least 1).
+func WithMaxRetries(n int) Option {
+	return func(con *Connection) { con.MaxRetries = n }
+}
+
+// WithTLS makes the connection use TLS with cfg.
+func WithTLS(cfg *tls.Config) Option {
+	return func(con *Connection) { con.TLSConfig = cfg }
+}
+
+// WithLogger sets the connection logger (DefaultLogger by default).
+func WithLogger(logger Logger) Option {
+	return func(con *Connection) { con.Logger = logger }
+}
+
+// WithReadBufferSize sets the socket read buffer size
+// (DefaultReadBufferSize by default).
+func WithReadBufferSize(size int) Option {
+	return func(con *Connection) { con.ReadBufferSize = size }
+}
+
+// WithAutoReconnect makes HandleEvents reconnect when the connection is lost,
+// see Connection.AutoReconnect.
+func WithAutoReconnect() Option {
+	return func(con *Connection) { con.AutoReconnect = true }
+}
+
+// WithTCPKeepAlive sets the TCP keepalive period (DefaultTCPKeepAlivePeriod by
+// default), a negative period disables it.
+func WithTCPKeepAlive(period time.Duration) Option {
+	return func(con *Connection) { con.TCPKeepAlivePeriod = period }
+}
+
+// WithStateChanges makes the StateChanges channel receive the transitions
+// from the connection creation on.
+func WithStateChanges() Option {
+	return func(con *Connection) {
+		con.stateMu.Lock()
+		defer con.stateMu.Unlock()
+		con.initStates()
+	}
+}
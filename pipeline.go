@@ -0,0 +1,137 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Pipeline is a batch of commands sent at once, without waiting for each
+// reply before sending the next command. Freeswitch replies in order: the
+// replies are matched to the commands by the connection reply queues.
+type Pipeline struct {
+	con  *Connection
+	buf  bytes.Buffer
+	cmds []pipelineCmd
+}
+
+// pipelineCmd is a queued pipeline command.
+type pipelineCmd struct {
+	name    string // command and args, for the errors
+	api     bool
+	waiter  *replyWaiter
+	replies *replyQueue
+}
+
+// PipelineReply is the reply to a pipeline command: Event is set on success,
+// Err (e.g. a *CommandError or an *APIError) otherwise.
+type PipelineReply struct {
+	Event *Event
+	Err   error
+}
+
+// Pipeline returns a new empty pipeline on con.
+func (con *Connection) Pipeline() *Pipeline {
+	return &Pipeline{con: con}
+}
+
+// SendRecv queues the command cmd with args, replied by a command reply.
+func (p *Pipeline) SendRecv(cmd string, args ...string) *Pipeline {
+	return p.add(false, cmd, args)
+}
+
+// Api queues the api command cmd with args, replied by an api response.
+func (p *Pipeline) Api(cmd string, args ...string) *Pipeline {
+	return p.add(true, cmd, args)
+}
+
+// Len returns the number of queued commands.
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+func (p *Pipeline) add(api bool, cmd string, args []string) *Pipeline {
+	start := p.buf.Len()
+	replies := &p.con.cmdReplies
+	if api {
+		replies = &p.con.apiResponses
+		p.buf.WriteString("api ")
+	}
+	p.buf.WriteString(cmd)
+	for _, arg := range args {
+		p.buf.WriteString(" ")
+		p.buf.WriteString(arg)
+	}
+	p.buf.WriteString("\n\n")
+	p.cmds = append(p.cmds, pipelineCmd{
+		name:    cmdString(cmd, args),
+		api:     api,
+		waiter:  &replyWaiter{ch: make(chan *Event, 1), cmd: commandName(p.buf.Bytes()[start:])},
+		replies: replies,
+	})
+	return p
+}
+
+// Exec sends the queued commands in a single write and returns their replies,
+// in order. The pipeline is then empty. The error is set if the commands can't
+// be sent, or if ctx is done or the connection lost before all the replies are
+// received: the replies are then partial.
+func (p *Pipeline) Exec(ctx context.Context) ([]PipelineReply, error) {
+	cmds := p.cmds
+	b := p.buf.Bytes()
+	p.cmds, p.buf = nil, bytes.Buffer{}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	con := p.con
+	lost := con.lostChan()
+	con.writeMu.Lock()
+	for _, c := range cmds {
+		c.replies.push(c.waiter)
+	}
+	_, err := con.write(b)
+	sent := time.Now()
+	con.writeMu.Unlock()
+	if err != nil {
+		err = &SendError{Err: err}
+		for _, c := range cmds {
+			con.commandDone(c.waiter, nil, err)
+		}
+		return nil, fmt.Errorf("pipeline: %w", err)
+	}
+	replies := make([]PipelineReply, 0, len(cmds))
+	for i, c := range cmds {
+		ev, err := con.waitReply(ctx, c.waiter, sent, lost)
+		if err == ErrBodyTooLarge {
+			// only this reply is lost, the following ones are still paired
+			replies = append(replies, PipelineReply{Err: fmt.Errorf("%s: %w", c.name, err)})
+			continue
+		}
+		if err != nil {
+			for _, c := range cmds[i+1:] {
+				con.commandDone(c.waiter, nil, err)
+			}
+			return replies, fmt.Errorf("pipeline %s: %w", c.name, err)
+		}
+		replies = append(replies, c.reply(ev))
+	}
+	return replies, nil
+}
+
+// reply interprets the reply ev to c, as SendRecv and Api do.
+func (c pipelineCmd) reply(ev *Event) PipelineReply {
+	if c.api {
+		if err := apiError(c.name, string(ev.RawBody)); err != nil {
+			return PipelineReply{Err: err}
+		}
+	} else if reply := ev.Get("Reply-Text"); strings.HasPrefix(reply, "-ERR") {
+		return PipelineReply{Err: &CommandError{Command: c.name, Reply: strings.TrimSpace(reply)}}
+	}
+	return PipelineReply{Event: ev}
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// latencyConnection returns a test connection whose replies are received
+// latency after their command is sent, in order.
+func latencyConnection(t testing.TB, latency time.Duration) *Connection {
+	con, s := pipeConnection(t, newTestHandler())
+	due := make(chan time.Time, 1024)
+	go func() {
+		for d := range due {
+			time.Sleep(time.Until(d))
+			s.reply("+OK")
+		}
+	}()
+	s.serve(func(cmd string) { due <- time.Now().Add(latency) })
+	handleEvents(con)
+	return con
+}
+
+func TestPipeline(t *testing.T) {
+	con, s := pipeConnection(t, newTestHandler())
+	s.serve(func(cmd string) {
+		if cmd == "api bad" {
+			s.apiResponse("-ERR bad command")
+		} else if strings.HasPrefix(cmd, "api ") {
+			s.apiResponse(strings.TrimPrefix(cmd, "api "))
+		} else {
+			s.reply("+OK " + cmd)
+		}
+	})
+	handleEvents(con)
+	replies, err := con.Pipeline().SendRecv("event plain ALL").Api("status").Api("bad").SendRecv("nolog").Exec(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 4 {
+		t.Fatalf("got %d replies, want 4", len(replies))
+	}
+	if got := replies[0].Event.Get("Reply-Text"); got != "+OK event plain ALL" {
+		t.Errorf("reply #1: got %q", got)
+	}
+	if got := string(replies[1].Event.RawBody); got != "status" {
+		t.Errorf("reply #2: got %q", got)
+	}
+	if _, ok := replies[2].Err.(*APIError); !ok {
+		t.Errorf("reply #3: got error %v, want an *APIError", replies[2].Err)
+	}
+	if got := replies[3].Event.Get("Reply-Text"); got != "+OK nolog" {
+		t.Errorf("reply #4: got %q", got)
+	}
+}
+
+const benchCommands = 100
+
+func BenchmarkSendRecvSequential(b *testing.B) {
+	con := latencyConnection(b, time.Millisecond)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchCommands; j++ {
+			if _, err := con.SendRecv(fmt.Sprintf("uuid_setvar %d foo bar", j)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	con := latencyConnection(b, time.Millisecond)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := con.Pipeline()
+		for j := 0; j < benchCommands; j++ {
+			p.SendRecv(fmt.Sprintf("uuid_setvar %d foo bar", j))
+		}
+		if _, err := p.Exec(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Pool is a set of connections to several freeswitch nodes. Api calls are
+// load balanced (round-robin) on the connected nodes. Each connection reconnects
+// on its own (AutoReconnect), and is replaced by a new one if it gives up.
+type Pool struct {
+	Handler ConnectionHandler
+	mu      sync.Mutex
+	nodes   []*poolNode
+	next    int
+	done    chan struct{} // closed by Close
+}
+
+// poolNode is a pool connection and the host it was added with.
+type poolNode struct {
+	host string
+	con  *Connection
+}
+
+// NewPool creates an empty pool whose connections use handler.
+func NewPool(handler ConnectionHandler) *Pool {
+	return &Pool{Handler: handler, done: make(chan struct{})}
+}
+
+// Add connects to the freeswitch node host with opts and adds it to the pool.
+func (p *Pool) Add(host string, opts ...Option) error {
+	// don't append to the caller's slice
+	opts = append(opts[:len(opts):len(opts)], WithAutoReconnect())
+	con, err := NewConnection(host, p.Handler, opts...)
+	if err != nil {
+		return fmt.Errorf("pool add %s: %w", host, err)
+	}
+	node := &poolNode{host: host, con: con}
+	p.mu.Lock()
+	p.init()
+	p.nodes = append(p.nodes, node)
+	p.mu.Unlock()
+	go p.serve(node, opts)
+	return nil
+}
+
+// init initializes p.done, for the pools created without NewPool. p.mu must
+// be held.
+func (p *Pool) init() {
+	if p.done == nil {
+		p.done = make(chan struct{})
+	}
+}
+
+// serve handles the events of node until the pool is closed. If its connection
+// gives up reconnecting, it is replaced by a new connection to the node host
+// with opts, retried with an exponential backoff.
+func (p *Pool) serve(node *poolNode, opts []Option) {
+	con, host := node.con, node.host
+	for {
+		if err := con.HandleEvents(); err != nil {
+			con.logf("ERR: pool connection %s: %v\n", host, err)
+		}
+		con.Close()
+		delay := time.Second
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-time.After(delay):
+			}
+			c, err := NewConnection(host, p.Handler, opts...)
+			if err == nil {
+				if !p.replace(node, c) {
+					c.Close()
+					return
+				}
+				con = c
+				break
+			}
+			con.logf("NOTICE: pool node %s: %v\n", host, err)
+			if delay *= 2; delay > 30*time.Second {
+				delay = 30 * time.Second
+			}
+		}
+	}
+}
+
+// replace replaces the connection of node by con. It returns false if the
+// pool is closed.
+func (p *Pool) replace(node *poolNode, con *Connection) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+	node.con = con
+	return true
+}
+
+// Healthy returns the hosts, as given to Add, of the connected nodes.
+func (p *Pool) Healthy() []string {
+	var hosts []string
+	for _, node := range p.connected() {
+		hosts = append(hosts, node.host)
+	}
+	return hosts
+}
+
+// Api runs the api command cmd with args on a connected node, retrying on the
+// next one if the command can't be sent. A command sent but not replied (e.g.
+// on ErrTimeout or ErrConnectionClosed) is not retried: it may have run.
+func (p *Pool) Api(cmd string, args ...string) (string, error) {
+	var resp string
+	err := p.try(func(con *Connection) error {
+		var err error
+		resp, err = con.Api(cmd, args...)
+		return err
+	})
+	return resp, err
+}
+
+// Originate is Connection.Originate on a connected node, see Api.
+func (p *Pool) Originate(dialstring, app, appArgs string, vars map[string]string) (string, error) {
+	var uuid string
+	err := p.try(func(con *Connection) error {
+		var err error
+		uuid, err = con.Originate(dialstring, app, appArgs, vars)
+		return err
+	})
+	return uuid, err
+}
+
+// Close closes all the pool connections.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.init()
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	nodes := p.nodes
+	p.nodes = nil
+	p.mu.Unlock()
+	for _, node := range nodes {
+		node.con.Close()
+	}
+}
+
+// try calls fn with each connected node in turn, starting with the next one in
+// the round-robin, until fn succeeds or fails otherwise than with a command
+// that can't be sent.
+func (p *Pool) try(fn func(con *Connection) error) error {
+	nodes := p.connected()
+	if len(nodes) == 0 {
+		return fmt.Errorf("pool: no connected node")
+	}
+	p.mu.Lock()
+	start := p.next
+	p.next++
+	p.mu.Unlock()
+	var err error
+	for i := range nodes {
+		con := nodes[(start+i)%len(nodes)].con
+		if err = fn(con); err == nil || !isNotSent(err) {
+			return err
+		}
+		con.logf("NOTICE: pool node %s: %v, trying next\n", con.Address, err)
+	}
+	return fmt.Errorf("pool: all nodes failed, last: %w", err)
+}
+
+// connected returns a copy of the connected nodes.
+func (p *Pool) connected() []poolNode {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var nodes []poolNode
+	for _, node := range p.nodes {
+		if node.con.IsConnected() {
+			nodes = append(nodes, *node)
+		}
+	}
+	return nodes
+}
+
+// isNotSent tells if err is a command that can't be sent, safe to retry on
+// another node.
+func isNotSent(err error) bool {
+	var sendErr *SendError
+	return errors.As(err, &sendErr)
+}
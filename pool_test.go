@@ -0,0 +1,100 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func withCommandTimeout(d time.Duration) Option {
+	return func(con *Connection) { con.CommandTimeout = d }
+}
+
+func TestPoolNoRetryAfterSend(t *testing.T) {
+	var sent atomic.Int32
+	handle := func(s *fakeServer) {
+		s.auth("+OK accepted")
+		// never replies
+		for cmd := s.readCmd(); cmd != ""; cmd = s.readCmd() {
+			sent.Add(1)
+		}
+	}
+	p := NewPool(newTestHandler())
+	defer p.Close()
+	for i := 0; i < 2; i++ {
+		if err := p.Add(listenFake(t, handle), withCommandTimeout(50*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := p.Originate("user/1000", "park", "", nil); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+	if n := sent.Load(); n != 1 {
+		t.Errorf("originate sent %d times, want once", n)
+	}
+}
+
+func TestPoolReplacesDeadNode(t *testing.T) {
+	var accepted atomic.Int32
+	addr := listenFake(t, func(s *fakeServer) {
+		switch accepted.Add(1) {
+		case 1:
+			// the first connection is lost
+			s.auth("+OK accepted")
+			return
+		case 2:
+			// and the reconnection refused
+			s.auth("-ERR invalid")
+			return
+		}
+		s.auth("+OK accepted")
+		for cmd := s.readCmd(); cmd != ""; cmd = s.readCmd() {
+			s.apiResponse("+OK")
+		}
+	})
+	p := NewPool(newTestHandler())
+	defer p.Close()
+	if err := p.Add(addr, WithMaxRetries(1)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := p.Api("status"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dead node not replaced")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := accepted.Load(); n < 3 {
+		t.Errorf("got %d connections, want a new one", n)
+	}
+}
+
+func TestPoolAdd(t *testing.T) {
+	addr := listenFake(t, func(s *fakeServer) {
+		s.auth("+OK accepted")
+		for cmd := s.readCmd(); cmd != ""; cmd = s.readCmd() {
+		}
+	})
+	p := NewPool(newTestHandler())
+	defer p.Close()
+	// spare capacity must not be written
+	opts := make([]Option, 1, 2)
+	opts[0] = withCommandTimeout(time.Second)
+	if err := p.Add(addr, opts...); err != nil {
+		t.Fatal(err)
+	}
+	if extra := opts[:2][1]; extra != nil {
+		t.Error("Add appended to the caller's options")
+	}
+	if hosts := p.Healthy(); len(hosts) != 1 || hosts[0] != addr {
+		t.Errorf("got healthy %v, want [%s]", hosts, addr)
+	}
+}
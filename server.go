@@ -0,0 +1,178 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Server is an outbound connections server.
+type Server struct {
+	// Addr is the TCP network address to listen on.
+	Addr string
+	// Handler handles the outbound connections.
+	Handler ConnectionHandler
+	// OnAccept, if set, is called with the remote address of each accepted
+	// socket, before the connect command is sent. Returning false closes the
+	// socket, e.g. to only accept connections from known freeswitch nodes.
+	OnAccept func(remoteAddr net.Addr) bool
+}
+
+// ListenAndServe listens on the TCP network address addr for outbound connections
+// from freeswitch (socket dialplan application) and serves each accepted socket
+// in its own goroutine as an outbound Connection using handler.
+func ListenAndServe(addr string, handler ConnectionHandler) error {
+	srv := &Server{Addr: addr, Handler: handler}
+	return srv.ListenAndServe()
+}
+
+// ListenAndServe listens on srv.Addr and serves the outbound connections, see
+// the ListenAndServe function.
+func (srv *Server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	defer ln.Close()
+	return srv.Serve(ln)
+}
+
+// Serve serves the outbound connections accepted on ln, each in its own
+// goroutine, until ln is closed. The other Accept errors (e.g. too many open
+// files) are retried with an exponential backoff, up to 1 second.
+func (srv *Server) Serve(ln net.Listener) error {
+	var delay time.Duration
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return fmt.Errorf("accept: %w", err)
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			DefaultLogger.Printf("ERR: accept: %v, retrying in %v\n", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		if srv.OnAccept != nil && !srv.OnAccept(c.RemoteAddr()) {
+			c.Close()
+			continue
+		}
+		go serve(c, srv.Handler)
+	}
+}
+
+// serve handles an accepted outbound socket until it is disconnected, then
+// closes it.
+func serve(c net.Conn, handler ConnectionHandler) {
+	con, err := NewOutboundConnection(c, handler)
+	if err != nil {
+		DefaultLogger.Printf("ERR: outbound connection from %s: %v\n", c.RemoteAddr(), err)
+		return
+	}
+	defer con.Close()
+	if err := con.HandleEvents(); err != nil {
+		con.logf("ERR: outbound connection from %s: %v\n", c.RemoteAddr(), err)
+	}
+}
+
+// NewOutboundConnection creates an outbound Connection on the socket c accepted
+// from freeswitch. It sends the connect command and stores the returned channel
+// data in con.ChannelData. No authentication is done in outbound mode.
+func NewOutboundConnection(c net.Conn, handler ConnectionHandler) (*Connection, error) {
+	con := Connection{
+		Address:  c.RemoteAddr().String(),
+		Handler:  handler,
+		Outbound: true,
+	}
+	con.setSocket(c)
+	if err := con.connect(); err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	return &con, nil
+}
+
+// connect sends the outbound connect command and reads the channel data reply.
+func (con *Connection) connect() error {
+	if _, err := con.Write([]byte("connect\n\n")); err != nil {
+		con.conn().Close()
+		return fmt.Errorf("send connect: %w", err)
+	}
+	ev, err := con.readEvent()
+	if err != nil {
+		con.conn().Close()
+		return fmt.Errorf("channel data: %w", err)
+	}
+	if ev.Type != EventCommandReply {
+		con.conn().Close()
+		return fmt.Errorf("bad reply type: %#v", ev.Type)
+	}
+	// channel data header values are url encoded
+	ev.Type = EventConnect
+	ev.Header.IsEscaped = true
+	ev.UId = ev.Get("Unique-ID")
+	ev.Name = eventName(ev.Get("Event-Name"))
+	con.ChannelData = ev
+	con.connected.Store(true)
+	con.setState(Authenticated)
+	return nil
+}
+
+// Linger asks freeswitch to keep the outbound socket open after the channel
+// hangup, so that the final events (e.g. CHANNEL_HANGUP_COMPLETE) are received.
+// HandleEvents keeps reading events after the disconnect notice, until
+// freeswitch closes the socket.
+func (con *Connection) Linger() error {
+	return con.sendOK("linger")
+}
+
+// NoLinger cancels Linger.
+func (con *Connection) NoLinger() error {
+	return con.sendOK("nolinger")
+}
+
+// Resume asks freeswitch to keep the channel of the outbound connection alive
+// if the socket is lost: the dialplan then continues after the socket
+// application instead of hanging up the call. Linger is the other way around:
+// it keeps the socket open once the channel is hung up. Both are independent
+// from the async and full flags of the socket application, and apply to the
+// current connection only.
+func (con *Connection) Resume() error {
+	if !con.Outbound {
+		return fmt.Errorf("resume: not an outbound connection")
+	}
+	return con.sendOK("resume")
+}
+
+// MyEvents subscribes, in the given format (con.DefaultEventFormat if empty),
+// to the events of the outbound connection channel (con.ChannelData) only.
+func (con *Connection) MyEvents(format string) error {
+	if !con.Outbound || con.ChannelData == nil {
+		return fmt.Errorf("myevents: not an outbound connection")
+	}
+	return con.sendOK("myevents", con.eventFormat(format))
+}
+
+// DivertEvents asks freeswitch to send the application events of the outbound
+// connection channel (e.g. from bgapi or the dialplan applications) over the
+// socket, to be handled as the other events, instead of giving them to the
+// running application.
+func (con *Connection) DivertEvents(on bool) error {
+	if !con.Outbound {
+		return fmt.Errorf("divert_events: not an outbound connection")
+	}
+	arg := "off"
+	if on {
+		arg = "on"
+	}
+	return con.sendOK("divert_events", arg)
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// closeHandler is a testHandler telling when OnClose is called.
+type closeHandler struct {
+	*testHandler
+	closed chan struct{}
+}
+
+func (h *closeHandler) OnClose(con *Connection) { close(h.closed) }
+
+// dialOutbound connects to the outbound server addr as freeswitch would,
+// answering the connect command.
+func dialOutbound(t *testing.T, addr string) *fakeServer {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	s := &fakeServer{c: c, r: bufio.NewReader(c)}
+	if cmd := s.readCmd(); cmd != "connect" {
+		t.Fatalf("got command %q, want connect", cmd)
+	}
+	s.send("Content-Type: command/reply\nReply-Text: +OK\nUnique-ID: 1234\nEvent-Name: CHANNEL_DATA\n\n")
+	return s
+}
+
+func TestServeClosesOnEOF(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	h := &closeHandler{testHandler: newTestHandler(), closed: make(chan struct{})}
+	srv := &Server{Handler: h}
+	go srv.Serve(ln)
+	s := dialOutbound(t, ln.Addr().String())
+	s.c.(*net.TCPConn).CloseWrite()
+	select {
+	case <-h.closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnClose not called")
+	}
+	s.c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := s.r.ReadByte(); err != io.EOF {
+		t.Errorf("got %v, want the socket closed (EOF)", err)
+	}
+}
+
+// flakyListener fails its first Accept calls with a temporary error.
+type flakyListener struct {
+	net.Listener
+	fails int
+}
+
+func (ln *flakyListener) Accept() (net.Conn, error) {
+	if ln.fails > 0 {
+		ln.fails--
+		return nil, syscall.EMFILE
+	}
+	return ln.Listener.Accept()
+}
+
+func TestServeRetriesAccept(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := &flakyListener{Listener: l, fails: 3}
+	h := &closeHandler{testHandler: newTestHandler(), closed: make(chan struct{})}
+	srv := &Server{Handler: h}
+	res := make(chan error, 1)
+	go func() { res <- srv.Serve(ln) }()
+	dialOutbound(t, l.Addr().String())
+	select {
+	case <-h.connected:
+	case <-time.After(time.Second):
+		t.Fatal("connection not served after the accept errors")
+	}
+	l.Close()
+	select {
+	case err := <-res:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("got %v, want net.ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve still running after the listener close")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SessionState is a call leg state, as tracked by a Session.
+type SessionState int
+
+const (
+	SessionNew      SessionState = iota // no channel event seen yet
+	SessionCreated                      // CHANNEL_CREATE
+	SessionAnswered                     // CHANNEL_ANSWER
+	SessionHangup                       // CHANNEL_HANGUP or CHANNEL_HANGUP_COMPLETE
+)
+
+var sessionStateNames = [...]string{
+	SessionNew:      "New",
+	SessionCreated:  "Created",
+	SessionAnswered: "Answered",
+	SessionHangup:   "Hangup",
+}
+
+func (s SessionState) String() string {
+	if s < 0 || int(s) >= len(sessionStateNames) {
+		return fmt.Sprintf("SessionState(%d)", s)
+	}
+	return sessionStateNames[s]
+}
+
+// Session tracks the lifecycle of a call leg from its channel events. The
+// CHANNEL_CREATE, CHANNEL_ANSWER, CHANNEL_HANGUP and CHANNEL_HANGUP_COMPLETE
+// events must be subscribed.
+type Session struct {
+	UUID string
+
+	con         *Connection
+	mu          sync.Mutex
+	state       SessionState
+	answeredAt  time.Time
+	hangupCause HangupCause
+	done        chan struct{}
+}
+
+// NewSession returns a Session tracking the channel uuid. It is fed by
+// HandleEvents until the CHANNEL_HANGUP_COMPLETE event or Stop.
+func (con *Connection) NewSession(uuid string) *Session {
+	s := &Session{UUID: uuid, con: con, done: make(chan struct{})}
+	con.handlersMu.Lock()
+	defer con.handlersMu.Unlock()
+	if con.sessions == nil {
+		con.sessions = make(map[string]*Session)
+	}
+	con.sessions[uuid] = s
+	return s
+}
+
+// State returns the current state of the session.
+func (s *Session) State() SessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// AnsweredAt returns the answer time, or the zero time if not answered.
+func (s *Session) AnsweredAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.answeredAt
+}
+
+// HangupCause returns the hangup cause, or an empty cause if not hung up.
+func (s *Session) HangupCause() HangupCause {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hangupCause
+}
+
+// Done returns a channel closed on CHANNEL_HANGUP_COMPLETE.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Stop stops the tracking of the session, which keeps its last state.
+func (s *Session) Stop() {
+	s.con.handlersMu.Lock()
+	defer s.con.handlersMu.Unlock()
+	if s.con.sessions[s.UUID] == s {
+		delete(s.con.sessions, s.UUID)
+	}
+}
+
+// update updates the session state from ev and tells if it is over.
+func (s *Session) update(ev *Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch ev.Name {
+	case CHANNEL_CREATE:
+		if s.state < SessionCreated {
+			s.state = SessionCreated
+		}
+	case CHANNEL_ANSWER:
+		if s.state < SessionAnswered {
+			s.state = SessionAnswered
+			s.answeredAt = eventTime(ev)
+		}
+	case CHANNEL_HANGUP, CHANNEL_HANGUP_COMPLETE:
+		s.state = SessionHangup
+		if s.hangupCause == "" {
+			s.hangupCause = ev.HangupCause()
+		}
+		if ev.Name == CHANNEL_HANGUP_COMPLETE {
+			close(s.done)
+			return true
+		}
+	}
+	return false
+}
+
+// eventTime returns the time of ev, or now if it has no timestamp.
+func eventTime(ev *Event) time.Time {
+	if t := ev.Time(); !t.IsZero() {
+		return t
+	}
+	return time.Now()
+}
+
+// feedSession passes ev to the session of its channel, if any.
+func (con *Connection) feedSession(ev *Event) {
+	if ev.UId == "" {
+		return
+	}
+	con.handlersMu.Lock()
+	defer con.handlersMu.Unlock()
+	s := con.sessions[ev.UId]
+	if s != nil && s.update(ev) {
+		delete(con.sessions, ev.UId)
+	}
+}
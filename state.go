@@ -0,0 +1,63 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import "fmt"
+
+// ConnState is a connection state, as sent on the StateChanges channel.
+type ConnState int
+
+const (
+	Connecting    ConnState = iota // dialing freeswitch
+	Authenticated                  // connected and authenticated
+	Disconnected                   // connection lost
+	Closed                         // connection closed by Close
+)
+
+var connStateNames = [...]string{
+	Connecting:    "Connecting",
+	Authenticated: "Authenticated",
+	Disconnected:  "Disconnected",
+	Closed:        "Closed",
+}
+
+func (s ConnState) String() string {
+	if s < 0 || int(s) >= len(connStateNames) {
+		return fmt.Sprintf("ConnState(%d)", s)
+	}
+	return connStateNames[s]
+}
+
+// StateChanges returns a channel receiving the connection state transitions
+// that happen after the first call, or since the connection creation with the
+// WithStateChanges option (to get the initial Connecting and Authenticated
+// transitions). The channel is buffered: transitions are dropped if it is full.
+func (con *Connection) StateChanges() <-chan ConnState {
+	con.stateMu.Lock()
+	defer con.stateMu.Unlock()
+	con.initStates()
+	return con.states
+}
+
+// initStates creates the StateChanges channel if needed. con.stateMu must be
+// held.
+func (con *Connection) initStates() {
+	if con.states == nil {
+		con.states = make(chan ConnState, 16)
+	}
+}
+
+// setState sends state to the StateChanges channel, if any, without blocking.
+func (con *Connection) setState(state ConnState) {
+	con.stateMu.Lock()
+	defer con.stateMu.Unlock()
+	if con.states == nil {
+		return
+	}
+	select {
+	case con.states <- state:
+	default:
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateChanges(t *testing.T) {
+	addr := listenFake(t, func(s *fakeServer) {
+		s.auth("+OK accepted")
+		s.readCmd()
+	})
+	con, err := NewConnection(addr, newTestHandler(), WithStateChanges())
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := con.StateChanges()
+	con.Close()
+	for _, want := range []ConnState{Connecting, Authenticated, Closed} {
+		select {
+		case got := <-states:
+			if got != want {
+				t.Fatalf("got state %s, want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s state", want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerStatus is the parsed output of the status api command.
+type ServerStatus struct {
+	Uptime               time.Duration
+	Version              string // e.g. "1.10.7 -release 64bit"
+	Ready                bool
+	SessionsSinceStartup int
+	Sessions             int // current sessions
+	SessionsPeak         int
+	SessionsPerSec       int
+	MaxSessionsPerSec    int
+	MaxSessions          int
+}
+
+var (
+	uptimeRe   = regexp.MustCompile(`(\d+) (year|day|hour|minute|second|millisecond|microsecond)s?`)
+	versionRe  = regexp.MustCompile(`\(Version ([^)]*)\)`)
+	sinceRe    = regexp.MustCompile(`^(\d+) session\(s\) since startup`)
+	sessionsRe = regexp.MustCompile(`^(\d+) session\(s\) - peak (\d+)`)
+	perSecRe   = regexp.MustCompile(`^(\d+) session\(s\) per Sec out of max (\d+)`)
+	maxRe      = regexp.MustCompile(`^(\d+) session\(s\) max`)
+)
+
+var uptimeUnits = map[string]time.Duration{
+	"year":        365 * 24 * time.Hour,
+	"day":         24 * time.Hour,
+	"hour":        time.Hour,
+	"minute":      time.Minute,
+	"second":      time.Second,
+	"millisecond": time.Millisecond,
+	"microsecond": time.Microsecond,
+}
+
+// Status returns the freeswitch status, from the status api command.
+func (con *Connection) Status() (*ServerStatus, error) {
+	resp, err := con.Api("status")
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(resp)
+}
+
+// parseStatus parses the output of the status api command.
+func parseStatus(resp string) (*ServerStatus, error) {
+	st := &ServerStatus{}
+	var ok bool
+	for _, line := range strings.Split(resp, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "UP "):
+			for _, m := range uptimeRe.FindAllStringSubmatch(line, -1) {
+				n, _ := strconv.Atoi(m[1])
+				st.Uptime += time.Duration(n) * uptimeUnits[m[2]]
+			}
+			ok = true
+		case versionRe.MatchString(line):
+			st.Version = versionRe.FindStringSubmatch(line)[1]
+			st.Ready = strings.HasSuffix(line, "is ready")
+		case sinceRe.MatchString(line):
+			st.SessionsSinceStartup = atoiMatch(sinceRe, line, 1)
+		case sessionsRe.MatchString(line):
+			st.Sessions = atoiMatch(sessionsRe, line, 1)
+			st.SessionsPeak = atoiMatch(sessionsRe, line, 2)
+		case perSecRe.MatchString(line):
+			st.SessionsPerSec = atoiMatch(perSecRe, line, 1)
+			st.MaxSessionsPerSec = atoiMatch(perSecRe, line, 2)
+		case maxRe.MatchString(line):
+			st.MaxSessions = atoiMatch(maxRe, line, 1)
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("parse status: no uptime line in %q", resp)
+	}
+	return st, nil
+}
+
+// atoiMatch returns the submatch i of re in s, as an int.
+func atoiMatch(re *regexp.Regexp, s string, i int) int {
+	n, _ := strconv.Atoi(re.FindStringSubmatch(s)[i])
+	return n
+}
+
+// SofiaProfile is an entry of the sofia status api command: a profile, a
+// gateway or an alias.
+type SofiaProfile struct {
+	Name  string
+	Type  string // "profile", "gateway" or "alias"
+	Data  string // the profile url, gateway uri or aliased profile
+	State string // e.g. "RUNNING", "REGED", "ALIASED"
+	Calls int    // current calls, for the profiles
+}
+
+// Sofia returns the sofia profiles, gateways and aliases, from the sofia
+// status api command.
+func (con *Connection) Sofia() ([]SofiaProfile, error) {
+	resp, err := con.Api("sofia", "status")
+	if err != nil {
+		return nil, err
+	}
+	return parseSofiaStatus(resp)
+}
+
+// parseSofiaStatus parses the output of the sofia status api command: the tab
+// separated lines between the ===== separators.
+func parseSofiaStatus(resp string) ([]SofiaProfile, error) {
+	var profiles []SofiaProfile
+	var in, seen bool
+	for _, line := range strings.Split(resp, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "=====") {
+			in, seen = !in, true
+			continue
+		}
+		if !in || strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("parse sofia status: bad line %q", line)
+		}
+		p := SofiaProfile{
+			Name:  strings.TrimSpace(fields[0]),
+			Type:  strings.TrimSpace(fields[1]),
+			Data:  strings.TrimSpace(fields[2]),
+			State: strings.TrimSpace(fields[3]),
+		}
+		if state, calls, ok := strings.Cut(p.State, " ("); ok {
+			p.State = state
+			p.Calls, _ = strconv.Atoi(strings.TrimSuffix(calls, ")"))
+		}
+		profiles = append(profiles, p)
+	}
+	if !seen {
+		return nil, fmt.Errorf("parse sofia status: unexpected output %q", resp)
+	}
+	return profiles, nil
+}
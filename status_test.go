@@ -0,0 +1,68 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseStatus(t *testing.T) {
+	st, err := parseStatus(statusOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ServerStatus{
+		Uptime: 2*24*time.Hour + 3*time.Hour + 4*time.Minute + 5*time.Second +
+			678*time.Millisecond + 901*time.Microsecond,
+		Version:              "1.10.7 -release 64bit",
+		Ready:                true,
+		SessionsSinceStartup: 1234,
+		Sessions:             5,
+		SessionsPeak:         42,
+		SessionsPerSec:       2,
+		MaxSessionsPerSec:    30,
+		MaxSessions:          1000,
+	}
+	if !reflect.DeepEqual(st, want) {
+		t.Errorf("got %+v, want %+v", st, want)
+	}
+	if _, err := parseStatus("-ERR no reply\n"); err == nil {
+		t.Error("no error on a bad status output")
+	}
+}
+
+const sofiaStatusOutput = "                     Name	   Type	                                       Data	State\n" +
+	"=================================================================================================\n" +
+	"            external-ipv6	profile	                   sip:mod_sofia@[::1]:5080	RUNNING (0)\n" +
+	"                 external	profile	            sip:mod_sofia@192.168.1.10:5080	RUNNING (0)\n" +
+	"    external::example.com	gateway	                    sip:joeuser@example.com	NOREG\n" +
+	"             192.168.1.10	  alias	                                   internal	ALIASED\n" +
+	"                 internal	profile	            sip:mod_sofia@192.168.1.10:5060	RUNNING (2)\n" +
+	"            internal-ipv6	profile	                   sip:mod_sofia@[::1]:5060	RUNNING (0)\n" +
+	"=================================================================================================\n" +
+	"4 profiles 1 alias\n"
+
+func TestParseSofiaStatus(t *testing.T) {
+	profiles, err := parseSofiaStatus(sofiaStatusOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SofiaProfile{
+		{Name: "external-ipv6", Type: "profile", Data: "sip:mod_sofia@[::1]:5080", State: "RUNNING"},
+		{Name: "external", Type: "profile", Data: "sip:mod_sofia@192.168.1.10:5080", State: "RUNNING"},
+		{Name: "external::example.com", Type: "gateway", Data: "sip:joeuser@example.com", State: "NOREG"},
+		{Name: "192.168.1.10", Type: "alias", Data: "internal", State: "ALIASED"},
+		{Name: "internal", Type: "profile", Data: "sip:mod_sofia@192.168.1.10:5060", State: "RUNNING", Calls: 2},
+		{Name: "internal-ipv6", Type: "profile", Data: "sip:mod_sofia@[::1]:5060", State: "RUNNING"},
+	}
+	if !reflect.DeepEqual(profiles, want) {
+		t.Errorf("got %+v, want %+v", profiles, want)
+	}
+	if _, err := parseSofiaStatus("-ERR Usage: sofia status\n"); err == nil {
+		t.Error("no error on a bad sofia status output")
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ApiStream sends the api command cmd with args and returns a reader over the
+// response body as it arrives, instead of buffering it. The events are not read
+// until the body is fully read or the reader is closed, which must be done.
+// A -ERR or -USAGE response is returned as an *APIError, as Api does.
+func (con *Connection) ApiStream(cmd string, args ...string) (io.ReadCloser, error) {
+	buf := bytes.NewBufferString("api " + cmd)
+	for _, arg := range args {
+		buf.WriteString(" ")
+		buf.WriteString(arg)
+	}
+	buf.WriteString("\n\n")
+
+	w := &replyWaiter{ch: make(chan *Event, 1), stream: true, gone: make(chan struct{})}
+	ev, err := con.exchangeWaiter(context.Background(), buf.Bytes(), &con.apiResponses, w)
+	if err != nil {
+		return nil, err
+	}
+	if ev.stream == nil {
+		// empty body
+		if err := apiError(cmdString(cmd, args), string(ev.RawBody)); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(ev.RawBody)), nil
+	}
+	// look for an error code at the start of the body
+	r := bufio.NewReader(ev.stream)
+	start, _ := r.Peek(len("-USAGE"))
+	if s := string(start); strings.HasPrefix(s, "-ERR") || strings.HasPrefix(s, "-USAGE") {
+		body, _ := io.ReadAll(r)
+		ev.stream.Close()
+		return nil, apiError(cmdString(cmd, args), string(body))
+	}
+	return &peekedStream{Reader: r, stream: ev.stream}, nil
+}
+
+// peekedStream reads a bodyStream through the reader its start was peeked with.
+type peekedStream struct {
+	*bufio.Reader
+	stream *bodyStream
+}
+
+func (s *peekedStream) Close() error {
+	return s.stream.Close()
+}
+
+// bodyStream reads a detached api response body from the connection socket
+// and tells the read loop when it is done.
+type bodyStream struct {
+	r    io.Reader
+	once sync.Once
+	done chan struct{}
+}
+
+func (s *bodyStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err == io.EOF {
+		s.once.Do(func() { close(s.done) })
+	}
+	return n, err
+}
+
+// Close skips the unread part of the body and releases the read loop.
+func (s *bodyStream) Close() error {
+	_, err := io.Copy(io.Discard, s.r)
+	s.once.Do(func() { close(s.done) })
+	return err
+}
+
+// detached tells if the event body was left unread by readEvent.
+func (e *Event) detached() bool {
+	return e.RawBody == nil && e.bodyLen > 0
+}
+
+// detachBody tells readEvent to leave the api response body unread when it is
+// for an ApiStream.
+func (con *Connection) detachBody(e *Event) bool {
+	if e.Get("Content-Type") != "api/response" {
+		return false
+	}
+	w := con.apiResponses.peek()
+	if w == nil || !w.stream {
+		return false
+	}
+	select {
+	case <-w.gone:
+		// abandoned: read the body as usual, to be dropped
+		return false
+	default:
+		return true
+	}
+}
+
+// streamBody hands the detached body of ev over to the waiting ApiStream and
+// waits until it is consumed. The body is skipped if the ApiStream caller gives
+// up waiting (e.g. on CommandTimeout) instead.
+func (con *Connection) streamBody(ev *Event) {
+	ev.stream = &bodyStream{
+		r:    io.LimitReader(con.buffer.Reader, ev.bodyLen),
+		done: make(chan struct{}),
+	}
+	w := con.apiResponses.pop()
+	if w == nil {
+		con.logf("ERR: unexpected reply dropped: [%s]\n", ev.Header)
+		ev.stream.Close()
+		return
+	}
+	w.ch <- ev
+	select {
+	case <-ev.stream.done:
+	case <-w.gone:
+		ev.stream.Close()
+	case <-con.lostChan():
+	}
+}
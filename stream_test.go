@@ -0,0 +1,77 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamServer answers "api slow" after 200ms, "api bad" with an error and
+// the other api commands with their name.
+func streamServer(s *fakeServer) {
+	s.serve(func(cmd string) {
+		switch cmd {
+		case "api slow":
+			time.Sleep(200 * time.Millisecond)
+			s.apiResponse(strings.Repeat("slow response\n", 1000))
+		case "api bad":
+			s.apiResponse("-USAGE: bad <arg>\n")
+		default:
+			s.apiResponse(strings.TrimPrefix(cmd, "api "))
+		}
+	})
+}
+
+func TestApiStream(t *testing.T) {
+	con, s := pipeConnection(t, newTestHandler())
+	streamServer(s)
+	handleEvents(con)
+	r, err := con.ApiStream("show", "channels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(body) != "show channels" {
+		t.Errorf("got %q, %v", body, err)
+	}
+	if resp, err := con.Api("next"); err != nil || resp != "next" {
+		t.Errorf("next: got %q, %v", resp, err)
+	}
+}
+
+func TestApiStreamError(t *testing.T) {
+	con, s := pipeConnection(t, newTestHandler())
+	streamServer(s)
+	handleEvents(con)
+	_, err := con.ApiStream("bad")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != APIErrUsage || apiErr.Reason != "bad <arg>" {
+		t.Fatalf("got %#v, want a usage *APIError", err)
+	}
+	if resp, err := con.Api("next"); err != nil || resp != "next" {
+		t.Errorf("next: got %q, %v", resp, err)
+	}
+}
+
+func TestApiStreamAbandoned(t *testing.T) {
+	con, s := pipeConnection(t, newTestHandler())
+	con.CommandTimeout = 100 * time.Millisecond
+	streamServer(s)
+	handleEvents(con)
+	if _, err := con.ApiStream("slow"); err != ErrTimeout {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+	con.CommandTimeout = time.Second
+	for _, cmd := range []string{"next", "last"} {
+		if resp, err := con.Api(cmd); err != nil || resp != cmd {
+			t.Errorf("%s: got %q, %v", cmd, resp, err)
+		}
+	}
+}
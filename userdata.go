@@ -0,0 +1,21 @@
+// Copyright 2017 Vallimamod Abdullah. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package esl
+
+// SetUserData sets con.UserData to v.
+func (con *Connection) SetUserData(v interface{}) {
+	con.userMu.Lock()
+	defer con.userMu.Unlock()
+	con.UserData = v
+}
+
+// UserDataAs returns con.UserData as a T, and false if it is not set or not a T
+// (instead of panicking as a bad type assertion would).
+func UserDataAs[T any](con *Connection) (T, bool) {
+	con.userMu.Lock()
+	defer con.userMu.Unlock()
+	v, ok := con.UserData.(T)
+	return v, ok
+}